@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+// board is what a bot knows about the other players, pieced together from
+// the deltas the server broadcasts. Units of players that haven't done
+// anything since the bot joined are missing.
+type board struct {
+	mu    sync.Mutex
+	self  string
+	units map[string]map[int]gamelogic.Unit
+}
+
+func newBoard(self string) *board {
+	return &board{
+		self:  self,
+		units: map[string]map[int]gamelogic.Unit{},
+	}
+}
+
+func (b *board) update(delta gamelogic.StateDelta) {
+	if delta.Error != "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, change := range delta.Changes {
+		if change.Username == b.self {
+			continue
+		}
+		units, ok := b.units[change.Username]
+		if !ok {
+			units = map[int]gamelogic.Unit{}
+			b.units[change.Username] = units
+		}
+		if change.Removed {
+			delete(units, change.Unit.ID)
+			continue
+		}
+		units[change.Unit.ID] = change.Unit
+	}
+}
+
+// enemies returns the known enemy units by location.
+func (b *board) enemies() map[gamelogic.Location][]gamelogic.Unit {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	enemies := map[gamelogic.Location][]gamelogic.Unit{}
+	for _, units := range b.units {
+		for _, unit := range units {
+			enemies[unit.Location] = append(enemies[unit.Location], unit)
+		}
+	}
+	return enemies
+}
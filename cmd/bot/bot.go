@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// bot plays like cmd/client, except that its commands come from a Strategy
+// instead of a keyboard.
+type bot struct {
+	gs       *gamelogic.GameState
+	board    *board
+	strategy Strategy
+	pub      pubsub.Publisher
+	think    time.Duration
+	rng      *rand.Rand
+
+	// the turn the queued orders are for
+	turn int
+}
+
+func newBot(name string, strategy Strategy, pub pubsub.Publisher, think time.Duration, rng *rand.Rand) *bot {
+	return &bot{
+		gs:       gamelogic.NewGameState(name),
+		board:    newBoard(name),
+		strategy: strategy,
+		pub:      pubsub.NewEnvelopePublisher(pub, "peril-bot", name),
+		think:    think,
+		rng:      rng,
+	}
+}
+
+// join subscribes to everything a client listens to and announces the bot
+// to the server. The subscriptions made are returned even on error, so they
+// can be closed.
+func (b *bot) join(broker pubsub.Broker) ([]*pubsub.Subscription, error) {
+	name := b.gs.GetUsername()
+	subs := []*pubsub.Subscription{}
+
+	sub, err := pubsub.Subscribe(broker, routing.ExchangePerilDirect, "pause."+name, routing.PauseKey, pubsub.TransientQueue, func(_ context.Context, d pubsub.Message[routing.PlayingState]) pubsub.AckType {
+		b.gs.HandlePause(d.Msg)
+		return pubsub.Ack
+	})
+	if err != nil {
+		return subs, err
+	}
+	subs = append(subs, sub)
+
+	sub, err = pubsub.Subscribe(broker, routing.ExchangePerilDirect, routing.TurnKey+"."+name, routing.TurnKey, pubsub.TransientQueue, func(_ context.Context, d pubsub.Message[routing.TurnStart]) pubsub.AckType {
+		b.gs.HandleTurnStart(d.Msg)
+		return pubsub.Ack
+	})
+	if err != nil {
+		return subs, err
+	}
+	subs = append(subs, sub)
+
+	sub, err = pubsub.Subscribe(broker, routing.ExchangePerilTopic, routing.WorldDeltasPrefix+"."+name, routing.WorldDeltasPrefix+".*", pubsub.TransientQueue, func(_ context.Context, d pubsub.Message[gamelogic.StateDelta]) pubsub.AckType {
+		b.board.update(d.Msg)
+		if d.Msg.Error != "" && d.Msg.Username == name {
+			log.Printf("%s: the server rejected a(n) %s: %s", name, d.Msg.Intent, d.Msg.Error)
+		}
+		b.gs.HandleDelta(d.Msg)
+		return pubsub.Ack
+	})
+	if err != nil {
+		return subs, err
+	}
+	subs = append(subs, sub)
+
+	return subs, b.send(gamelogic.Intent{Kind: gamelogic.IntentJoin, Username: name})
+}
+
+// run thinks, acts and repeats until ctx is done.
+func (b *bot) run(ctx context.Context) {
+	name := b.gs.GetUsername()
+	for {
+		// Spread the bots out instead of having them all act at once.
+		delay := b.think/2 + time.Duration(b.rng.Int63n(int64(b.think)+1))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		words := b.strategy.Next(View{
+			Me:       b.gs.GetPlayerSnap(),
+			Treasury: b.gs.Treasury(),
+			Enemies:  b.board.enemies(),
+		})
+		if words == nil {
+			continue
+		}
+		err := b.act(words)
+		if err != nil {
+			log.Printf("%s: %v", name, err)
+			continue
+		}
+		log.Printf("%s: %v", name, words)
+	}
+}
+
+func (b *bot) act(words []string) error {
+	var intent gamelogic.Intent
+	var err error
+	switch words[0] {
+	case "spawn":
+		intent, err = b.gs.CommandSpawn(words)
+	case "move":
+		intent, err = b.gs.CommandMove(words)
+	default:
+		err = fmt.Errorf("unknown command: %s", words[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	if !b.gs.InTurnMode() {
+		return b.send(intent)
+	}
+	// Unlike a player, a bot resubmits everything it has queued after every
+	// command, so the server always has its latest plan.
+	if b.gs.Orders().Turn != b.turn {
+		b.gs.ClearOrders()
+		b.turn = b.gs.Orders().Turn
+	}
+	err = b.gs.QueueOrder(intent)
+	if err != nil {
+		return err
+	}
+	batch := b.gs.Orders()
+	return pubsub.Publish(context.Background(), b.pub, pubsub.JSON, routing.ExchangePerilTopic, routing.OrdersPrefix+"."+batch.Username, batch)
+}
+
+func (b *bot) send(intent gamelogic.Intent) error {
+	err := pubsub.Publish(context.Background(), b.pub, pubsub.JSON, routing.ExchangePerilTopic, routing.IntentsPrefix+"."+intent.Username, intent)
+	var unroutable *pubsub.UnroutableError
+	if errors.As(err, &unroutable) {
+		return fmt.Errorf("nobody is listening for intents: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topology"
+)
+
+const testTimeout = 5 * time.Second
+
+func TestJoin(t *testing.T) {
+	conn := pubsub.NewMemoryBroker().Connect()
+	t.Cleanup(func() { conn.Close() })
+	err := topology.Peril().Apply(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	intents := make(chan pubsub.Message[gamelogic.Intent], 1)
+	intentSub, err := pubsub.Subscribe(conn, routing.ExchangePerilTopic, routing.IntentsPrefix+".test", routing.IntentsPrefix+".*", pubsub.TransientQueue, func(_ context.Context, d pubsub.Message[gamelogic.Intent]) pubsub.AckType {
+		intents <- d
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { intentSub.Close(context.Background()) })
+
+	rng := rand.New(rand.NewSource(1))
+	b := newBot("bot1", &greedyStrategy{rng: rng}, conn, time.Second, rng)
+	b.gs.SetOutput(io.Discard)
+	subs, err := b.join(conn)
+	t.Cleanup(func() { pubsub.CloseAll(context.Background(), subs...) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case d := <-intents:
+		if d.Msg.Kind != gamelogic.IntentJoin || d.Msg.Username != "bot1" {
+			t.Errorf("got intent %+v, want bot1 joining", d.Msg)
+		}
+		if d.Metadata.AppID != "peril-bot" || d.Metadata.UserID != "bot1" {
+			t.Errorf("got envelope %+v", d.Metadata)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("bot never joined")
+	}
+
+	// The bot learns where the other players are from their deltas.
+	pub := pubsub.NewEnvelopePublisher(conn, "peril-server", "")
+	err = pubsub.Publish(context.Background(), pub, pubsub.JSON, routing.ExchangePerilTopic, routing.WorldDeltasPrefix+".alice", gamelogic.StateDelta{
+		Intent:   gamelogic.IntentSpawn,
+		Username: "alice",
+		Changes: []gamelogic.UnitChange{
+			{Username: "alice", Unit: gamelogic.Unit{ID: 1, Rank: gamelogic.RankInfantry, Location: "asia"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(testTimeout)
+	for len(b.board.enemies()["asia"]) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("bot never saw alice's infantry")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
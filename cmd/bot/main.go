@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topology"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	count := flag.Int("count", 1, "number of bots to run")
+	think := flag.Duration("think", 2*time.Second, "average time a bot takes between commands")
+	seed := flag.Int64("seed", 0, "seed for the bots' decisions (default random)")
+	strategyName := flag.String("strategy", "greedy", "how the bots play: random or greedy")
+	prefix := flag.String("name", "bot", "prefix for the bots' usernames")
+	mapPath := flag.String("map", "", "map file to play on, must match the server's (default built-in map)")
+	verbose := flag.Bool("verbose", false, "print the game output of every bot")
+	login := flag.String("login", "guest", "RabbitMQ user to log in as, which needs the impersonator tag to publish as the bots")
+	password := flag.String("password", "", "password of the RabbitMQ user, or $PERIL_PASSWORD (default guest)")
+	flag.Parse()
+
+	if *think <= 0 {
+		log.Fatalln("-think has to be positive, got", *think)
+	}
+
+	if *mapPath != "" {
+		m, err := gamelogic.LoadMap(*mapPath)
+		if err != nil {
+			log.Fatalln("Error loading map:", err)
+		}
+		gamelogic.SetMap(m)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	log.Printf("Starting %v %s bot(s) with seed %v", *count, *strategyName, *seed)
+
+	if *password == "" {
+		*password = os.Getenv("PERIL_PASSWORD")
+	}
+	if *password == "" {
+		*password = "guest"
+	}
+	broker, err := pubsub.DialAMQP(pubsub.AMQPURL("localhost:5672", *login, *password))
+	if err != nil {
+		log.Fatalln("Error connecting to RabbitMQ:", err)
+	}
+	defer broker.Close()
+
+	err = topology.Peril().Apply(broker)
+	if err != nil {
+		log.Fatalln("Error declaring topology:", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	subs := []*pubsub.Subscription{}
+	for i := 0; i < *count; i++ {
+		rng := rand.New(rand.NewSource(*seed + int64(i)))
+		strategy, err := newStrategy(*strategyName, rng)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		b := newBot(fmt.Sprintf("%s%d", *prefix, i+1), strategy, broker.ConfirmedPublisher(), *think, rng)
+		// Everything gamelogic prints is meant for a human at a terminal,
+		// which a room full of bots doesn't have.
+		if !*verbose {
+			b.gs.SetOutput(io.Discard)
+		}
+		botSubs, err := b.join(broker)
+		subs = append(subs, botSubs...)
+		if err != nil {
+			log.Println("Error joining the game:", err)
+			break
+		}
+		go b.run(ctx)
+	}
+
+	<-ctx.Done()
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = pubsub.CloseAll(shutdownCtx, subs...)
+	if err != nil {
+		log.Println("Error draining subscriptions:", err)
+	}
+}
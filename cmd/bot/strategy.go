@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+// View is everything a strategy gets to decide on.
+type View struct {
+	Me       gamelogic.Player
+	Treasury int
+	Enemies  map[gamelogic.Location][]gamelogic.Unit
+}
+
+// Strategy picks a bot's next command, in the words a player would type, or
+// returns nil to wait.
+type Strategy interface {
+	Next(v View) []string
+}
+
+func newStrategy(name string, rng *rand.Rand) (Strategy, error) {
+	switch name {
+	case "random":
+		return &randomStrategy{rng: rng}, nil
+	case "greedy":
+		return &greedyStrategy{rng: rng}, nil
+	}
+	return nil, fmt.Errorf("unknown strategy: %s", name)
+}
+
+var ranks = []gamelogic.UnitRank{gamelogic.RankInfantry, gamelogic.RankCavalry, gamelogic.RankArtillery}
+
+// randomStrategy flips a coin between moving a random unit somewhere it can
+// reach and spawning a random unit it can afford.
+type randomStrategy struct {
+	rng *rand.Rand
+}
+
+func (s *randomStrategy) Next(v View) []string {
+	m := gamelogic.CurrentMap()
+	units := sortedUnits(v.Me)
+	if len(units) > 0 && s.rng.Intn(2) == 0 {
+		unit := units[s.rng.Intn(len(units))]
+		targets := reachable(m, unit)
+		if len(targets) > 0 {
+			target := targets[s.rng.Intn(len(targets))]
+			return []string{"move", string(target), strconv.Itoa(unit.ID)}
+		}
+	}
+
+	affordable := []gamelogic.UnitRank{}
+	for _, rank := range ranks {
+		if gamelogic.SpawnCost(rank) <= v.Treasury {
+			affordable = append(affordable, rank)
+		}
+	}
+	locations := spawnLocations(m, v)
+	if len(affordable) == 0 || len(locations) == 0 {
+		return nil
+	}
+	rank := affordable[s.rng.Intn(len(affordable))]
+	location := locations[s.rng.Intn(len(locations))]
+	return []string{"spawn", string(location), string(rank)}
+}
+
+// greedyStrategy attacks the weakest enemy location it can beat from where
+// its units stand. With nobody to beat it builds up, spawning the strongest
+// unit it can afford.
+type greedyStrategy struct {
+	rng *rand.Rand
+}
+
+func (s *greedyStrategy) Next(v View) []string {
+	m := gamelogic.CurrentMap()
+	byLocation := map[gamelogic.Location][]gamelogic.Unit{}
+	for _, unit := range sortedUnits(v.Me) {
+		byLocation[unit.Location] = append(byLocation[unit.Location], unit)
+	}
+
+	var (
+		bestTarget gamelogic.Location
+		bestPower  int
+		bestUnits  []gamelogic.Unit
+	)
+	for _, from := range m.Locations() {
+		if len(byLocation[from]) == 0 {
+			continue
+		}
+		for _, target := range m.Locations() {
+			enemies := v.Enemies[target]
+			if len(enemies) == 0 {
+				continue
+			}
+			attackers := []gamelogic.Unit{}
+			for _, unit := range byLocation[from] {
+				dist, ok := m.Distance(from, target)
+				if ok && dist <= m.Range(unit.Rank) {
+					attackers = append(attackers, unit)
+				}
+			}
+			power := gamelogic.PowerLevel(enemies)
+			if gamelogic.PowerLevel(attackers) <= power {
+				continue
+			}
+			if bestUnits == nil || power < bestPower {
+				bestTarget, bestPower, bestUnits = target, power, attackers
+			}
+		}
+	}
+	if bestUnits != nil {
+		words := []string{"move", string(bestTarget)}
+		for _, unit := range bestUnits {
+			words = append(words, strconv.Itoa(unit.ID))
+		}
+		return words
+	}
+
+	locations := spawnLocations(m, v)
+	if len(locations) == 0 {
+		return nil
+	}
+	for _, rank := range slices.Backward(ranks) {
+		if gamelogic.SpawnCost(rank) > v.Treasury {
+			continue
+		}
+		// Build up where the money is.
+		best := locations[s.rng.Intn(len(locations))]
+		for _, loc := range locations {
+			if m.Income(loc) > m.Income(best) {
+				best = loc
+			}
+		}
+		return []string{"spawn", string(best), string(rank)}
+	}
+	return nil
+}
+
+// spawnLocations are the locations the player holds, or, before they have
+// any units, the ones no known enemy holds.
+func spawnLocations(m *gamelogic.WorldMap, v View) []gamelogic.Location {
+	locations := []gamelogic.Location{}
+	for _, loc := range m.Locations() {
+		held := false
+		for _, unit := range v.Me.Units {
+			held = held || unit.Location == loc
+		}
+		if held || len(v.Me.Units) == 0 && len(v.Enemies[loc]) == 0 {
+			locations = append(locations, loc)
+		}
+	}
+	return locations
+}
+
+// reachable are the other locations the unit can move to in one go.
+func reachable(m *gamelogic.WorldMap, unit gamelogic.Unit) []gamelogic.Location {
+	locations := []gamelogic.Location{}
+	for _, loc := range m.Locations() {
+		dist, ok := m.Distance(unit.Location, loc)
+		if ok && dist > 0 && dist <= m.Range(unit.Rank) {
+			locations = append(locations, loc)
+		}
+	}
+	return locations
+}
+
+func sortedUnits(p gamelogic.Player) []gamelogic.Unit {
+	units := []gamelogic.Unit{}
+	for _, unit := range p.Units {
+		units = append(units, unit)
+	}
+	slices.SortFunc(units, func(a, b gamelogic.Unit) int { return a.ID - b.ID })
+	return units
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+func TestGreedyStrategy(t *testing.T) {
+	s := &greedyStrategy{rng: rand.New(rand.NewSource(1))}
+
+	// From europe, infantry and cavalry are worth 6: enough for americas
+	// and asia, not for africa. Asia is the weaker of the two.
+	me := gamelogic.Player{Username: "bot1", Units: map[int]gamelogic.Unit{
+		1: {ID: 1, Rank: gamelogic.RankInfantry, Location: "europe"},
+		2: {ID: 2, Rank: gamelogic.RankCavalry, Location: "europe"},
+	}}
+	enemies := map[gamelogic.Location][]gamelogic.Unit{
+		"americas": {{ID: 1, Rank: gamelogic.RankCavalry, Location: "americas"}},
+		"asia":     {{ID: 2, Rank: gamelogic.RankInfantry, Location: "asia"}},
+		"africa":   {{ID: 3, Rank: gamelogic.RankArtillery, Location: "africa"}},
+	}
+	got := s.Next(View{Me: me, Enemies: enemies})
+	if want := []string{"move", "asia", "1", "2"}; !slices.Equal(got, want) {
+		t.Errorf("attacked with %v, want %v", got, want)
+	}
+
+	// With nobody to beat, it spawns the strongest unit it can afford in
+	// the richest free location.
+	enemies = map[gamelogic.Location][]gamelogic.Unit{
+		"europe": {{ID: 1, Rank: gamelogic.RankArtillery, Location: "europe"}},
+	}
+	got = s.Next(View{Me: gamelogic.Player{Username: "bot1"}, Treasury: 10, Enemies: enemies})
+	if len(got) != 3 || got[0] != "spawn" || got[1] != "americas" && got[1] != "asia" || got[2] != string(gamelogic.RankArtillery) {
+		t.Errorf("spawned with %v, want an artillery in americas or asia", got)
+	}
+
+	if got := s.Next(View{Me: gamelogic.Player{Username: "bot1"}}); got != nil {
+		t.Errorf("got %v without any money, want to wait", got)
+	}
+}
+
+// The server has to accept every command the random strategy comes up with
+// while it builds an army and moves it around.
+func TestRandomStrategyPlaysByTheRules(t *testing.T) {
+	s := &randomStrategy{rng: rand.New(rand.NewSource(1))}
+	world := gamelogic.NewWorld()
+	world.SetOutput(io.Discard)
+	gs := gamelogic.NewGameState("bot1")
+	gs.SetOutput(io.Discard)
+	world.HandleIntent(gamelogic.Intent{Kind: gamelogic.IntentJoin, Username: "bot1"})
+
+	moved := false
+	for range 100 {
+		words := s.Next(View{Me: gs.GetPlayerSnap(), Treasury: gs.Treasury()})
+		if words == nil {
+			continue
+		}
+		var intent gamelogic.Intent
+		var err error
+		switch words[0] {
+		case "spawn":
+			intent, err = gs.CommandSpawn(words)
+		case "move":
+			intent, err = gs.CommandMove(words)
+			moved = true
+		default:
+			t.Fatalf("unknown command %v", words)
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", words, err)
+		}
+		delta := world.HandleIntent(intent)
+		if delta.Error != "" {
+			t.Fatalf("server rejected %v: %s", words, delta.Error)
+		}
+		gs.HandleDelta(delta)
+	}
+	if len(gs.GetPlayerSnap().Units) == 0 || !moved {
+		t.Errorf("never built an army and moved it, got %+v", gs.GetPlayerSnap())
+	}
+}
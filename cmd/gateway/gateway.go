@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	loginTimeout = 10 * time.Second
+	maxFrameSize = 4096
+)
+
+// Usernames end up in routing keys, where dots and wildcards mean something.
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// ClientFrame is what a browser sends. The first frame of a connection has
+// to be a login, every one after it a command.
+type ClientFrame struct {
+	Type     string
+	Username string `json:",omitempty"`
+	// Token is the hex HMAC-SHA256 of the username under the gateway's
+	// secret, when it has one.
+	Token string `json:",omitempty"`
+	// Command holds the words a player would type into cmd/client, like
+	// ["move", "europe", "1", "2"].
+	Command []string `json:",omitempty"`
+}
+
+const (
+	FrameLogin   = "login"
+	FrameCommand = "command"
+)
+
+// ServerFrame is what the gateway sends. Payload depends on Type.
+type ServerFrame struct {
+	Type    string
+	Error   string `json:",omitempty"`
+	Payload any    `json:",omitempty"`
+}
+
+const (
+	FrameWelcome   = "welcome"   // Status
+	FrameStatus    = "status"    // Status
+	FrameOrders    = "orders"    // gamelogic.OrderBatch
+	FramePause     = "pause"     // routing.PlayingState
+	FrameTurn      = "turn"      // routing.TurnStart
+	FrameDelta     = "delta"     // gamelogic.StateDelta
+	FrameDiplomacy = "diplomacy" // gamelogic.DiplomacyMessage
+	FrameOK        = "ok"
+	FrameError     = "error"
+)
+
+// gateway lets browsers play over WebSockets. Each connection gets a
+// session that subscribes and publishes on the player's behalf, exactly like
+// cmd/client would.
+type gateway struct {
+	broker   pubsub.Broker
+	pub      pubsub.Publisher
+	secret   string
+	upgrader websocket.Upgrader
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	wg       sync.WaitGroup
+}
+
+// newGateway accepts browsers on pages from origin, or without one from the
+// gateway's own host, which is websocket.Upgrader's default.
+func newGateway(broker pubsub.Broker, pub pubsub.Publisher, secret, origin string) *gateway {
+	g := &gateway{
+		broker:   broker,
+		pub:      pub,
+		secret:   secret,
+		sessions: map[string]*session{},
+	}
+	if origin != "" {
+		g.upgrader.CheckOrigin = func(r *http.Request) bool {
+			return r.Header.Get("Origin") == origin
+		}
+	}
+	return g
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already answered the request.
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxFrameSize)
+
+	name, err := g.login(conn)
+	if err != nil {
+		conn.WriteJSON(ServerFrame{Type: FrameError, Error: err.Error()})
+		return
+	}
+	s := newSession(conn, name, g.pub)
+	if !g.register(s) {
+		s.send(ServerFrame{Type: FrameError, Error: fmt.Sprintf("%s is already playing", name)})
+		return
+	}
+	defer g.unregister(s)
+
+	log.Printf("%s connected from %s", name, r.RemoteAddr)
+	err = s.play(g.broker)
+	if err != nil {
+		log.Printf("%s: %v", name, err)
+	}
+	log.Printf("%s disconnected", name)
+}
+
+func (g *gateway) login(conn *websocket.Conn) (string, error) {
+	conn.SetReadDeadline(time.Now().Add(loginTimeout))
+	frame := ClientFrame{}
+	err := conn.ReadJSON(&frame)
+	if err != nil {
+		return "", fmt.Errorf("could not read login: %v", err)
+	}
+	if frame.Type != FrameLogin {
+		return "", errors.New("log in first")
+	}
+	if !validUsername.MatchString(frame.Username) {
+		return "", errors.New("usernames are 1 to 32 letters, digits, dashes or underscores")
+	}
+	if g.secret != "" && !hmac.Equal([]byte(frame.Token), []byte(signUsername(g.secret, frame.Username))) {
+		return "", errors.New("invalid token")
+	}
+	return frame.Username, nil
+}
+
+// signUsername is the token that lets a player log in as username.
+func signUsername(secret, username string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(username))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (g *gateway) register(s *session) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.sessions[s.name]; ok {
+		return false
+	}
+	g.sessions[s.name] = s
+	g.wg.Add(1)
+	return true
+}
+
+func (g *gateway) unregister(s *session) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.sessions, s.name)
+	g.wg.Done()
+}
+
+// closeAll disconnects every player and waits for their sessions to clean
+// up, until ctx is done.
+func (g *gateway) closeAll(ctx context.Context) {
+	g.mu.Lock()
+	for _, s := range g.sessions {
+		s.close()
+	}
+	g.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Println("Gave up waiting for sessions to close")
+	}
+}
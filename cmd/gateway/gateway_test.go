@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestGatewayOrigin(t *testing.T) {
+	tests := []struct {
+		name, origin, from string
+		ok                 bool
+	}{
+		{"no browser", "", "", true},
+		{"same host", "", "same", true},
+		{"other host", "", "http://evil.example", false},
+		{"allowed origin", "http://peril.example", "http://peril.example", true},
+		{"other origin", "http://peril.example", "http://evil.example", false},
+		{"no origin", "http://peril.example", "", false},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(newGateway(nil, nil, "", tt.origin))
+		header := http.Header{}
+		switch tt.from {
+		case "":
+		case "same":
+			header.Set("Origin", srv.URL)
+		default:
+			header.Set("Origin", tt.from)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), header)
+		if err == nil {
+			conn.Close()
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+		if !tt.ok && resp != nil && resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: got status %d, want 403", tt.name, resp.StatusCode)
+		}
+		srv.Close()
+	}
+}
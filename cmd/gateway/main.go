@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topology"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	addr := flag.String("addr", ":8081", "address to accept WebSocket connections on")
+	secret := flag.String("secret", "", "secret login tokens are signed with, required unless -insecure")
+	insecure := flag.Bool("insecure", false, "without a -secret, let anyone log in as anyone, e.g. for local games")
+	origin := flag.String("origin", "", "only accept connections from pages served from this origin (default the gateway's own host)")
+	mapPath := flag.String("map", "", "map file to play on, must match the server's (default built-in map)")
+	login := flag.String("login", "guest", "RabbitMQ user to log in as, which needs the impersonator tag to publish as its players")
+	password := flag.String("password", "", "password of the RabbitMQ user, or $PERIL_PASSWORD (default guest)")
+	flag.Parse()
+
+	// gateway -secret S token <username> prints the token a web backend
+	// would hand to that player.
+	if flag.Arg(0) == "token" {
+		if *secret == "" || flag.NArg() != 2 {
+			fmt.Println("usage: gateway -secret <secret> token <username>")
+			os.Exit(1)
+		}
+		fmt.Println(signUsername(*secret, flag.Arg(1)))
+		return
+	}
+
+	if *mapPath != "" {
+		m, err := gamelogic.LoadMap(*mapPath)
+		if err != nil {
+			log.Fatalln("Error loading map:", err)
+		}
+		gamelogic.SetMap(m)
+	}
+	if *secret == "" && !*insecure {
+		log.Fatalln("The gateway needs a -secret to authenticate players, or -insecure to let anyone play as anyone")
+	}
+	if *secret == "" {
+		log.Println("No -secret given, players are not authenticated")
+	}
+
+	if *password == "" {
+		*password = os.Getenv("PERIL_PASSWORD")
+	}
+	if *password == "" {
+		*password = "guest"
+	}
+	broker, err := pubsub.DialAMQP(pubsub.AMQPURL("localhost:5672", *login, *password))
+	if err != nil {
+		log.Fatalln("Error connecting to RabbitMQ:", err)
+	}
+	defer broker.Close()
+
+	err = topology.Peril().Apply(broker)
+	if err != nil {
+		log.Fatalln("Error declaring topology:", err)
+	}
+
+	gw := newGateway(broker, broker.ConfirmedPublisher(), *secret, *origin)
+	mux := http.NewServeMux()
+	mux.Handle("GET /ws", gw)
+	srv := &http.Server{
+		Addr:    *addr,
+		Handler: mux,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println("Error serving WebSockets:", err)
+			stop()
+		}
+	}()
+	log.Printf("Accepting players on ws://%s/ws", *addr)
+
+	<-ctx.Done()
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	// Hijacked connections are not tracked by Shutdown, so close the
+	// sessions ourselves.
+	err = srv.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Println("Error stopping HTTP server:", err)
+	}
+	gw.closeAll(shutdownCtx)
+}
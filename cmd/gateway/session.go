@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait / 2
+)
+
+// Status is a player's side of the game, as sent in welcome and status
+// frames.
+type Status struct {
+	Player   gamelogic.Player
+	Treasury int
+	Income   gamelogic.Income
+}
+
+// session is one player's connection. Like cmd/client it keeps a GameState
+// that follows the server's deltas, so commands are checked before they go
+// out.
+type session struct {
+	name string
+	conn *websocket.Conn
+	gs   *gamelogic.GameState
+	pub  pubsub.Publisher
+
+	// gorilla/websocket allows only one writer at a time.
+	writeMu sync.Mutex
+}
+
+func newSession(conn *websocket.Conn, name string, pub pubsub.Publisher) *session {
+	s := &session{
+		name: name,
+		conn: conn,
+		gs:   gamelogic.NewGameState(name),
+		pub:  pubsub.NewEnvelopePublisher(pub, "peril-gateway", name),
+	}
+	// Everything gamelogic prints is meant for a human at a terminal. The
+	// players here get it over their sockets instead.
+	s.gs.SetOutput(io.Discard)
+	return s
+}
+
+// play joins the game and runs the player's commands until they disconnect.
+func (s *session) play(broker pubsub.Broker) error {
+	subs, err := s.join(broker)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		pubsub.CloseAll(ctx, subs...)
+	}()
+	if err != nil {
+		s.send(ServerFrame{Type: FrameError, Error: "could not join the game"})
+		return err
+	}
+	err = s.send(ServerFrame{Type: FrameWelcome, Payload: s.status()})
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.keepAlive(done)
+
+	s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		frame := ClientFrame{}
+		err := s.conn.ReadJSON(&frame)
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		reply := ServerFrame{Type: FrameOK}
+		err = s.handle(frame, &reply)
+		if err != nil {
+			reply = ServerFrame{Type: FrameError, Error: err.Error()}
+		}
+		err = s.send(reply)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// join subscribes to everything cmd/client listens to and announces the
+// player to the server. The subscriptions made are returned even on error,
+// so they can be closed.
+func (s *session) join(broker pubsub.Broker) ([]*pubsub.Subscription, error) {
+	subs := []*pubsub.Subscription{}
+
+	sub, err := pubsub.Subscribe(broker, routing.ExchangePerilDirect, "pause."+s.name, routing.PauseKey, pubsub.TransientQueue, func(_ context.Context, d pubsub.Message[routing.PlayingState]) pubsub.AckType {
+		s.gs.HandlePause(d.Msg)
+		s.send(ServerFrame{Type: FramePause, Payload: d.Msg})
+		return pubsub.Ack
+	})
+	if err != nil {
+		return subs, err
+	}
+	subs = append(subs, sub)
+
+	sub, err = pubsub.Subscribe(broker, routing.ExchangePerilDirect, routing.TurnKey+"."+s.name, routing.TurnKey, pubsub.TransientQueue, func(_ context.Context, d pubsub.Message[routing.TurnStart]) pubsub.AckType {
+		s.gs.HandleTurnStart(d.Msg)
+		s.send(ServerFrame{Type: FrameTurn, Payload: d.Msg})
+		return pubsub.Ack
+	})
+	if err != nil {
+		return subs, err
+	}
+	subs = append(subs, sub)
+
+	sub, err = pubsub.Subscribe(broker, routing.ExchangePerilTopic, routing.DiplomacyPrefix+"."+s.name, routing.DiplomacyPrefix+"."+s.name, pubsub.TransientQueue, func(_ context.Context, d pubsub.Message[gamelogic.DiplomacyMessage]) pubsub.AckType {
+		s.gs.HandleDiplomacy(d.Msg)
+		s.send(ServerFrame{Type: FrameDiplomacy, Payload: d.Msg})
+		return pubsub.Ack
+	})
+	if err != nil {
+		return subs, err
+	}
+	subs = append(subs, sub)
+
+	sub, err = pubsub.Subscribe(broker, routing.ExchangePerilTopic, routing.WorldDeltasPrefix+"."+s.name, routing.WorldDeltasPrefix+".*", pubsub.TransientQueue, func(_ context.Context, d pubsub.Message[gamelogic.StateDelta]) pubsub.AckType {
+		s.gs.HandleDelta(d.Msg)
+		s.send(ServerFrame{Type: FrameDelta, Payload: d.Msg})
+		return pubsub.Ack
+	})
+	if err != nil {
+		return subs, err
+	}
+	subs = append(subs, sub)
+
+	return subs, s.publishIntent(gamelogic.Intent{Kind: gamelogic.IntentJoin, Username: s.name})
+}
+
+// handle runs a command frame, filling in reply when the command answers
+// with more than ok.
+func (s *session) handle(frame ClientFrame, reply *ServerFrame) error {
+	if frame.Type != FrameCommand || len(frame.Command) == 0 {
+		return errors.New("expected a command")
+	}
+	words := frame.Command
+	switch words[0] {
+	case "spawn":
+		intent, err := s.gs.CommandSpawn(words)
+		if err != nil {
+			return err
+		}
+		return s.sendIntent(intent)
+	case "move":
+		intent, err := s.gs.CommandMove(words)
+		if err != nil {
+			return err
+		}
+		return s.sendIntent(intent)
+	case "status":
+		*reply = ServerFrame{Type: FrameStatus, Payload: s.status()}
+		return nil
+	case "ally", "truce", "break", "accept", "reject":
+		msg, err := s.gs.CommandDiplomacy(words)
+		if err != nil {
+			return err
+		}
+		return pubsub.Publish(context.Background(), s.pub, pubsub.JSON, routing.ExchangePerilTopic, routing.DiplomacyPrefix+"."+msg.To, msg)
+	case "orders":
+		*reply = ServerFrame{Type: FrameOrders, Payload: s.gs.Orders()}
+		return nil
+	case "submit":
+		if !s.gs.InTurnMode() {
+			return errors.New("the game is not turn based")
+		}
+		batch := s.gs.Orders()
+		err := pubsub.Publish(context.Background(), s.pub, pubsub.JSON, routing.ExchangePerilTopic, routing.OrdersPrefix+"."+batch.Username, batch)
+		if err != nil {
+			return err
+		}
+		s.gs.ClearOrders()
+		return nil
+	}
+	return fmt.Errorf("unknown command: %s", words[0])
+}
+
+func (s *session) status() Status {
+	return Status{
+		Player:   s.gs.GetPlayerSnap(),
+		Treasury: s.gs.Treasury(),
+		Income:   s.gs.Income(),
+	}
+}
+
+// sendIntent queues the intent as an order when the server plays in turns,
+// and sends it right away otherwise.
+func (s *session) sendIntent(intent gamelogic.Intent) error {
+	if s.gs.InTurnMode() {
+		return s.gs.QueueOrder(intent)
+	}
+	return s.publishIntent(intent)
+}
+
+func (s *session) publishIntent(intent gamelogic.Intent) error {
+	err := pubsub.Publish(context.Background(), s.pub, pubsub.JSON, routing.ExchangePerilTopic, routing.IntentsPrefix+"."+intent.Username, intent)
+	var unroutable *pubsub.UnroutableError
+	if errors.As(err, &unroutable) {
+		return fmt.Errorf("nobody is listening for intents: %v", err)
+	}
+	return err
+}
+
+func (s *session) send(frame ServerFrame) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return s.conn.WriteJSON(frame)
+}
+
+// keepAlive pings the browser until done, so dead connections time out.
+func (s *session) keepAlive(done <-chan struct{}) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// close disconnects the player, which ends play.
+func (s *session) close() {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(writeWait))
+	s.conn.Close()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topology"
+)
+
+//go:embed dashboard.html
+var dashboard []byte
+
+// PlayerInfo is a player as the admin API shows them. Connected is whether
+// their client is consuming deltas, and null when RabbitMQ can't tell.
+type PlayerInfo struct {
+	Username  string
+	Units     int
+	Treasury  int
+	Connected *bool
+}
+
+// adminUser is who logs in to the admin API, with the -admin-password.
+const adminUser = "admin"
+
+// newAdminHandler serves the JSON admin API under /api and the dashboard
+// at /.
+func newAdminHandler(world *gamelogic.World, pub pubsub.Publisher, logs *gamelogic.LogStore, dedup *pubsub.Deduplicator, metrics map[string]*pubsub.HandlerMetrics, mgmt managementAPI) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboard)
+	})
+
+	mux.HandleFunc("GET /api/state", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, routing.PlayingState{IsPaused: world.Snapshot().Paused})
+	})
+	mux.HandleFunc("POST /api/pause", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println("\nPausing the game from the admin API...")
+		fmt.Print("> ")
+		setPaused(world, pub, true)
+		writeJSON(w, http.StatusOK, routing.PlayingState{IsPaused: true})
+	})
+	mux.HandleFunc("POST /api/resume", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println("\nResuming the game from the admin API...")
+		fmt.Print("> ")
+		setPaused(world, pub, false)
+		writeJSON(w, http.StatusOK, routing.PlayingState{IsPaused: false})
+	})
+
+	mux.HandleFunc("GET /api/players", func(w http.ResponseWriter, r *http.Request) {
+		consumers := map[string]int{}
+		queues, err := topology.FetchQueues(mgmt.URL, mgmt.Username, mgmt.Password)
+		for _, q := range queues {
+			consumers[q.Name] = q.Consumers
+		}
+		players := []PlayerInfo{}
+		for _, snap := range world.Snapshot().Players {
+			info := PlayerInfo{
+				Username: snap.Player.Username,
+				Units:    len(snap.Player.Units),
+				Treasury: snap.Treasury,
+			}
+			if err == nil {
+				connected := consumers[routing.WorldDeltasPrefix+"."+info.Username] > 0
+				info.Connected = &connected
+			}
+			players = append(players, info)
+		}
+		writeJSON(w, http.StatusOK, players)
+	})
+
+	mux.HandleFunc("GET /api/logs", func(w http.ResponseWriter, r *http.Request) {
+		q := gamelogic.LogQuery{
+			Username: r.FormValue("user"),
+			Grep:     r.FormValue("grep"),
+			Limit:    defaultLogsLimit,
+		}
+		if since := r.FormValue("since"); since != "" {
+			t, err := parseSince(since)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("since %v", err))
+				return
+			}
+			q.Since = t
+		}
+		if limit := r.FormValue("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, errors.New("limit must be a non-negative integer"))
+				return
+			}
+			q.Limit = n
+		}
+		found, err := logs.Query(q)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, found)
+	})
+
+	mux.HandleFunc("GET /api/dedup", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, dedup.Stats())
+	})
+
+	mux.HandleFunc("GET /api/handlers", func(w http.ResponseWriter, r *http.Request) {
+		stats := map[string]pubsub.HandlerStats{}
+		for name, m := range metrics {
+			stats[name] = m.Stats()
+		}
+		writeJSON(w, http.StatusOK, stats)
+	})
+
+	mux.HandleFunc("GET /api/queues", func(w http.ResponseWriter, r *http.Request) {
+		queues, err := topology.FetchQueues(mgmt.URL, mgmt.Username, mgmt.Password)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		slices.SortFunc(queues, func(a, b topology.QueueStats) int {
+			return b.Messages - a.Messages
+		})
+		writeJSON(w, http.StatusOK, queues)
+	})
+	return mux
+}
+
+// requireAdmin asks for the admin's password with basic auth, which the
+// browser remembers for the dashboard's requests. Without a password the API
+// is open to anyone, but only to read. Browsers send the password along
+// with requests from any site, so changes also have to come from the
+// dashboard's own origin.
+func requireAdmin(next http.Handler, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if password != "" {
+			user, pass, ok := r.BasicAuth()
+			userOK := subtle.ConstantTimeCompare([]byte(user), []byte(adminUser)) == 1
+			passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+			if !ok || !userOK || !passOK {
+				w.Header().Set("WWW-Authenticate", `Basic realm="peril", charset="UTF-8"`)
+				writeError(w, http.StatusUnauthorized, errors.New("wrong user or password"))
+				return
+			}
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if password == "" {
+			writeError(w, http.StatusForbidden, errors.New("the server needs an -admin-password to allow changes"))
+			return
+		}
+		if !sameOrigin(r) {
+			writeError(w, http.StatusForbidden, errors.New("cross-origin request refused"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin reports whether the request comes from a page served by this
+// server. Requests without an Origin don't come from a browser.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		fmt.Println("Error writing admin response:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"Error": err.Error()})
+}
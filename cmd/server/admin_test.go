@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	tests := []struct {
+		name, password, method, user, pass, origin string
+		want                                       int
+	}{
+		{"open read", "", http.MethodGet, "", "", "", http.StatusNoContent},
+		{"open change", "", http.MethodPost, "", "", "", http.StatusForbidden},
+		{"no login", "secret", http.MethodGet, "", "", "", http.StatusUnauthorized},
+		{"wrong password", "secret", http.MethodPost, adminUser, "guess", "", http.StatusUnauthorized},
+		{"wrong user", "secret", http.MethodGet, "alice", "secret", "", http.StatusUnauthorized},
+		{"read", "secret", http.MethodGet, adminUser, "secret", "", http.StatusNoContent},
+		{"change", "secret", http.MethodPost, adminUser, "secret", "", http.StatusNoContent},
+		{"change from the dashboard", "secret", http.MethodPost, adminUser, "secret", "http://example.com", http.StatusNoContent},
+		{"change from another site", "secret", http.MethodPost, adminUser, "secret", "http://evil.example", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://example.com/api/pause", nil)
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, tt.pass)
+		}
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		w := httptest.NewRecorder()
+		requireAdmin(ok, tt.password).ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: no WWW-Authenticate challenge", tt.name)
+		}
+	}
+}
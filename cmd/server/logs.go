@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+const defaultLogsLimit = 20
+
+const logsUsage = `usage: logs [--user U] [--since T] [--grep "some text"] [--limit N]`
+
+// commandLogs prints the logs matching the REPL arguments. --since takes
+// either an RFC 3339 time or a duration back from now, like 15m. Values
+// with spaces in them go in quotes.
+func commandLogs(store *gamelogic.LogStore, args []string) error {
+	q := gamelogic.LogQuery{Limit: defaultLogsLimit}
+	for i := 0; i < len(args); i++ {
+		flag := args[i]
+		if i+1 >= len(args) {
+			return fmt.Errorf("missing value for %s\n%s", flag, logsUsage)
+		}
+		value, words, err := quotedValue(args[i+1:])
+		if err != nil {
+			return fmt.Errorf("%s %v\n%s", flag, err, logsUsage)
+		}
+		i += words
+		switch flag {
+		case "--user":
+			q.Username = value
+		case "--since":
+			since, err := parseSince(value)
+			if err != nil {
+				return fmt.Errorf("--since %v\n%s", err, logsUsage)
+			}
+			q.Since = since
+		case "--grep":
+			q.Grep = value
+		case "--limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 0 {
+				return fmt.Errorf("--limit must be a non-negative integer\n%s", logsUsage)
+			}
+			q.Limit = limit
+		default:
+			return fmt.Errorf("unknown flag %s\n%s", flag, logsUsage)
+		}
+	}
+
+	logs, err := store.Query(q)
+	if err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		fmt.Println("No logs found.")
+		return nil
+	}
+	for _, gamelog := range logs {
+		fmt.Printf("%v %v: %v\n", gamelog.CurrentTime.Format(time.RFC3339), gamelog.Username, gamelog.Message)
+	}
+	return nil
+}
+
+// quotedValue is the value at the start of args and how many of them it
+// took up. A value in double or single quotes runs up to the word that
+// closes them. The REPL has already split the line on whitespace, so the
+// words are joined back with single spaces.
+func quotedValue(args []string) (string, int, error) {
+	first := args[0]
+	if first == "" || !strings.ContainsRune(`"'`, rune(first[0])) {
+		return first, 1, nil
+	}
+	quote := first[:1]
+	words := []string{}
+	for i, word := range args {
+		if i == 0 {
+			word = word[1:]
+		}
+		if strings.HasSuffix(word, quote) {
+			words = append(words, strings.TrimSuffix(word, quote))
+			return strings.Join(words, " "), i + 1, nil
+		}
+		words = append(words, word)
+	}
+	return "", 0, fmt.Errorf("is missing its closing %s", quote)
+}
+
+func parseSince(value string) (time.Time, error) {
+	ago, err := time.ParseDuration(value)
+	if err == nil {
+		return time.Now().Add(-ago), nil
+	}
+	since, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("must be a duration like 15m or an RFC 3339 time")
+	}
+	return since, nil
+}
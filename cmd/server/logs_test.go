@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuotedValue(t *testing.T) {
+	tests := []struct {
+		line  string
+		want  string
+		words int
+	}{
+		{`alice --limit 5`, "alice", 1},
+		{`"won a war" --limit 5`, "won a war", 3},
+		{`'won a war'`, "won a war", 3},
+		{`"war"`, "war", 1},
+		{`""`, "", 1},
+		{`" war "`, " war ", 3},
+		{`"it's over"`, "it's over", 2},
+	}
+	for _, tt := range tests {
+		// Like the REPL splits it.
+		args := strings.Fields(tt.line)
+		got, words, err := quotedValue(args)
+		if err != nil || got != tt.want || words != tt.words {
+			t.Errorf("quotedValue(%q) = %q, %d, %v, want %q, %d", args, got, words, err, tt.want, tt.words)
+		}
+	}
+
+	_, _, err := quotedValue([]string{`"won`, "a", "war"})
+	if err == nil {
+		t.Error("accepted an unclosed quote")
+	}
+}
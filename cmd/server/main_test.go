@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topology"
+)
+
+const testTimeout = 5 * time.Second
+
+func TestHandlerIntent(t *testing.T) {
+	broker := pubsub.NewMemoryBroker()
+	conn := broker.Connect()
+	t.Cleanup(func() { conn.Close() })
+	err := topology.Peril().Apply(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := pubsub.NewEnvelopePublisher(conn, "peril-server", "")
+
+	world := gamelogic.NewWorld()
+	world.SetCombatResolver(gamelogic.ClassicResolver{})
+	intentSub, err := pubsub.Subscribe(conn, routing.ExchangePerilTopic, routing.IntentsPrefix, routing.IntentsPrefix+".*", pubsub.DurableQueue, handlerIntent(world, pub, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deltas := make(chan pubsub.Message[gamelogic.StateDelta], 4)
+	deltaSub, err := pubsub.Subscribe(conn, routing.ExchangePerilTopic, routing.WorldDeltasPrefix+".test", routing.WorldDeltasPrefix+".alice", pubsub.TransientQueue, func(_ context.Context, d pubsub.Message[gamelogic.StateDelta]) pubsub.AckType {
+		deltas <- d
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pubsub.CloseAll(context.Background(), intentSub, deltaSub) })
+
+	aliceConn := broker.ConnectAs("alice")
+	t.Cleanup(func() { aliceConn.Close() })
+	alice := pubsub.NewEnvelopePublisher(aliceConn, "peril-client", "alice")
+	send := func(intent gamelogic.Intent) pubsub.Message[gamelogic.StateDelta] {
+		t.Helper()
+		err := pubsub.Publish(context.Background(), alice, pubsub.JSON, routing.ExchangePerilTopic, routing.IntentsPrefix+".alice", intent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case d := <-deltas:
+			return d
+		case <-time.After(testTimeout):
+			t.Fatal("no state delta")
+		}
+		return pubsub.Message[gamelogic.StateDelta]{}
+	}
+
+	d := send(gamelogic.Intent{
+		Kind:     gamelogic.IntentSpawn,
+		Username: "alice",
+		Location: "europe",
+		Rank:     gamelogic.RankInfantry,
+	})
+	if d.Msg.Error != "" {
+		t.Fatalf("spawn rejected: %s", d.Msg.Error)
+	}
+	if len(d.Msg.Changes) != 1 || d.Msg.Changes[0].Unit.Location != "europe" {
+		t.Errorf("got changes %+v", d.Msg.Changes)
+	}
+	if d.Metadata.AppID != "peril-server" || d.Metadata.CausationID == "" {
+		t.Errorf("got envelope %+v", d.Metadata)
+	}
+	if snap := world.Snapshot(); len(snap.Players) != 1 {
+		t.Errorf("world has %d players, want 1", len(snap.Players))
+	}
+
+	d = send(gamelogic.Intent{
+		Kind:     gamelogic.IntentSpawn,
+		Username: "alice",
+		Location: "atlantis",
+		Rank:     gamelogic.RankInfantry,
+	})
+	if d.Msg.Error == "" {
+		t.Errorf("spawned in atlantis: %+v", d.Msg)
+	}
+}
+
+func TestVerifySender(t *testing.T) {
+	tests := []struct {
+		key, userID, keyName, username string
+		ok                             bool
+	}{
+		{"intents.alice", "alice", "alice", "alice", true},
+		{"intents.bob", "alice", "alice", "alice", false},
+		{"intents.alice", "bob", "alice", "alice", false},
+		{"intents.alice", "", "alice", "alice", false},
+		{"intents.alice.x", "alice", "alice", "alice", false},
+		{"diplomacy.bob", "alice", "bob", "alice", true},
+		{"diplomacy.alice", "alice", "bob", "alice", false},
+	}
+	for _, tt := range tests {
+		d := pubsub.Delivery{
+			RoutingKey: tt.key,
+			Metadata:   pubsub.Metadata{UserID: tt.userID},
+		}
+		err := verifySender(d, tt.keyName, tt.username)
+		if (err == nil) != tt.ok {
+			t.Errorf("verifySender(%s from %q, %s, %s) = %v", tt.key, tt.userID, tt.keyName, tt.username, err)
+		}
+	}
+}
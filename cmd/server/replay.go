@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/replay"
+)
+
+// startRecording records the match from the world as it is now on.
+func startRecording(broker pubsub.Broker, world *gamelogic.World, path, mapPath, combat string, seed int64) (*replay.Recorder, *pubsub.Subscription, error) {
+	snap := world.Snapshot()
+	meta := replay.Meta{
+		Started:  time.Now(),
+		Seed:     seed,
+		Combat:   combat,
+		Snapshot: &snap,
+	}
+	if mapPath != "" {
+		data, err := os.ReadFile(mapPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read map: %v", err)
+		}
+		meta.Map = data
+	}
+
+	recorder, err := replay.Create(path, meta)
+	if err != nil {
+		return nil, nil, err
+	}
+	sub, err := recorder.Tap(broker)
+	if err != nil {
+		recorder.Close()
+		return nil, nil, err
+	}
+	return recorder, sub, nil
+}
+
+func commandReplay(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: server replay <file>")
+	}
+	rec, err := replay.Load(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Replaying %d message(s) from a match started %s...\n", len(rec.Records), rec.Meta.Started.Format(time.RFC3339))
+
+	// The replayed clients print everything a player would have seen.
+	result, err := replay.Replay(rec, io.Discard)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%d war(s):\n", len(result.Wars))
+	for _, war := range result.Wars {
+		war.Print(os.Stdout)
+	}
+
+	names := []string{}
+	for name := range result.Clients {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	fmt.Printf("\n%d player(s):\n", len(names))
+	for _, name := range names {
+		gs := result.Clients[name]
+		p := gs.GetPlayerSnap()
+		fmt.Printf("%s has %d unit(s) and %d gold:\n", name, len(p.Units), gs.Treasury())
+		ids := []int{}
+		for id := range p.Units {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		for _, id := range ids {
+			unit := p.Units[id]
+			fmt.Printf("* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
+		}
+	}
+
+	if len(result.Mismatches) > 0 {
+		fmt.Printf("\nThe replay diverged from the recording in %d place(s):\n", len(result.Mismatches))
+		for _, m := range result.Mismatches {
+			fmt.Println("*", m)
+		}
+		return errors.New("replay is not deterministic")
+	}
+	fmt.Println("\nThe replay matches the recording.")
+	return nil
+}
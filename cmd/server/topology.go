@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topology"
+)
+
+func commandTopology(args []string, mgmt managementAPI) error {
+	if len(args) == 0 {
+		return errors.New("usage: server topology apply|diff|export")
+	}
+
+	peril := topology.Peril()
+	switch args[0] {
+	case "export":
+		return peril.Definitions().Export(os.Stdout)
+	case "diff":
+		have, err := topology.FetchDefinitions(mgmt.URL, mgmt.Username, mgmt.Password)
+		if err != nil {
+			return err
+		}
+		diffs := topology.Diff(peril.Definitions(), have)
+		if len(diffs) == 0 {
+			fmt.Println("Topology is up to date.")
+			return nil
+		}
+		for _, d := range diffs {
+			fmt.Println("*", d)
+		}
+		return nil
+	case "apply":
+		broker, err := pubsub.DialAMQP(connectionString)
+		if err != nil {
+			return err
+		}
+		defer broker.Close()
+		err = peril.Apply(broker)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Topology applied.")
+		return nil
+	}
+	return fmt.Errorf("unknown topology command: %s", args[0])
+}
@@ -0,0 +1,214 @@
+// Package bridge republishes what happens in a match to MQTT, for spectators
+// on devices too small to speak AMQP.
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const publishTimeout = 5 * time.Second
+
+// Event is a message on its way to MQTT. Payload is sent as JSON.
+type Event struct {
+	Topic   string
+	Payload any
+	// Retain keeps the event on the broker for spectators who subscribe
+	// later.
+	Retain bool
+}
+
+// Rule maps the messages bound by BindingKey on Exchange to events.
+// Translate returns no events for messages spectators should not see.
+type Rule struct {
+	Name       string
+	Exchange   string
+	BindingKey string
+	Translate  func(d pubsub.Delivery) ([]Event, error)
+}
+
+// Topic maps a routing key to an MQTT topic below root: the prefix becomes a
+// level of its own, like routing keys' first word, and the rest of the key
+// the next one.
+//
+//	army_moves.alice -> peril/army_moves/alice
+func Topic(root, prefix, key string) string {
+	return root + "/" + prefix + "/" + topicLevel(key)
+}
+
+// topicLevel makes s safe for a single topic level: slashes would add
+// levels, and + and # are wildcards.
+func topicLevel(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '+', '#', 0:
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// Rules are the default mapping:
+//
+//	pause on peril_direct   -> <root>/pause, retained
+//	world_deltas.*          -> <root>/army_moves/<player> for unit changes
+//	                           <root>/war/<attacker> for wars
+//	game_logs.*             -> <root>/game_logs/<player>
+func Rules(root string) []Rule {
+	return []Rule{
+		{
+			Name:       routing.PauseKey,
+			Exchange:   routing.ExchangePerilDirect,
+			BindingKey: routing.PauseKey,
+			Translate: func(d pubsub.Delivery) ([]Event, error) {
+				ps := routing.PlayingState{}
+				err := decode(d, &ps)
+				if err != nil {
+					return nil, err
+				}
+				return []Event{{
+					Topic:   root + "/" + routing.PauseKey,
+					Payload: PauseEvent{Paused: ps.IsPaused},
+					Retain:  true,
+				}}, nil
+			},
+		},
+		{
+			Name:       routing.WorldDeltasPrefix,
+			Exchange:   routing.ExchangePerilTopic,
+			BindingKey: routing.WorldDeltasPrefix + ".*",
+			Translate: func(d pubsub.Delivery) ([]Event, error) {
+				delta := gamelogic.StateDelta{}
+				err := decode(d, &delta)
+				if err != nil {
+					return nil, err
+				}
+				// Rejected commands are between the player and the server.
+				if delta.Error != "" {
+					return nil, nil
+				}
+				events := []Event{}
+				for _, army := range armyEvents(delta) {
+					events = append(events, Event{
+						Topic:   Topic(root, routing.ArmyMovesPrefix, army.Player),
+						Payload: army,
+					})
+				}
+				for _, war := range delta.Wars {
+					events = append(events, Event{
+						Topic:   Topic(root, routing.WarRecognitionsPrefix, war.Attacker),
+						Payload: warEvent(delta.Seq, war),
+					})
+				}
+				return events, nil
+			},
+		},
+		{
+			Name:       routing.GameLogSlug,
+			Exchange:   routing.ExchangePerilTopic,
+			BindingKey: routing.GameLogSlug + ".*",
+			Translate: func(d pubsub.Delivery) ([]Event, error) {
+				gamelog := routing.GameLog{}
+				err := decode(d, &gamelog)
+				if err != nil {
+					return nil, err
+				}
+				return []Event{{
+					Topic: Topic(root, routing.GameLogSlug, gamelog.Username),
+					Payload: LogEvent{
+						Time:    gamelog.CurrentTime,
+						Player:  gamelog.Username,
+						Message: sanitizeMessage(gamelog.Message),
+					},
+				}}, nil
+			},
+		},
+	}
+}
+
+func decode(d pubsub.Delivery, v any) error {
+	codec, ok := pubsub.CodecFor(d.ContentType)
+	if !ok {
+		return fmt.Errorf("unsupported content type: %s", d.ContentType)
+	}
+	return codec.Unmarshal(d.Body, v)
+}
+
+// Bridge consumes every rule's messages from a transient queue of its own
+// and publishes the events to MQTT.
+type Bridge struct {
+	name   string
+	client mqtt.Client
+	qos    byte
+	rules  []Rule
+}
+
+// New creates a bridge. name keeps the queues of several bridges apart.
+func New(name string, client mqtt.Client, qos byte, rules []Rule) *Bridge {
+	return &Bridge{
+		name:   name,
+		client: client,
+		qos:    qos,
+		rules:  rules,
+	}
+}
+
+// Start subscribes to every rule. The subscriptions made are returned even
+// on error, so they can be closed.
+func (b *Bridge) Start(broker pubsub.Broker) ([]*pubsub.Subscription, error) {
+	subs := []*pubsub.Subscription{}
+	for _, rule := range b.rules {
+		queue := "bridge." + b.name + "." + rule.Name
+		err := broker.DeclareAndBind(rule.Exchange, queue, rule.BindingKey, pubsub.TransientQueue)
+		if err != nil {
+			return subs, err
+		}
+		sub, err := broker.Consume(queue, 0, b.handler(rule))
+		if err != nil {
+			return subs, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// handler publishes the events of every message. Spectating is best effort,
+// so messages are acked even when MQTT is unreachable.
+func (b *Bridge) handler(rule Rule) func(pubsub.Delivery) pubsub.AckType {
+	return func(d pubsub.Delivery) pubsub.AckType {
+		events, err := rule.Translate(d)
+		if err != nil {
+			fmt.Printf("Error translating %s message: %v\n", rule.Name, err)
+			return pubsub.NackDiscard
+		}
+		for _, event := range events {
+			err := b.publish(event)
+			if err != nil {
+				fmt.Printf("Error publishing to %s: %v\n", event.Topic, err)
+			}
+		}
+		return pubsub.Ack
+	}
+}
+
+func (b *Bridge) publish(event Event) error {
+	payload, err := json.Marshal(event.Payload)
+	if err != nil {
+		return err
+	}
+	token := b.client.Publish(event.Topic, b.qos, event.Retain, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("timed out after %v", publishTimeout)
+	}
+	return token.Error()
+}
@@ -0,0 +1,239 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/bridge/mqtttest"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topology"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const testTimeout = 5 * time.Second
+
+func TestTopic(t *testing.T) {
+	tests := []struct {
+		prefix, key, want string
+	}{
+		{routing.ArmyMovesPrefix, "alice", "peril/army_moves/alice"},
+		{routing.WarRecognitionsPrefix, "a/b", "peril/war/a_b"},
+		{routing.GameLogSlug, "+", "peril/game_logs/_"},
+		{routing.GameLogSlug, "#", "peril/game_logs/_"},
+		{routing.GameLogSlug, "x+y#z", "peril/game_logs/x_y_z"},
+		{routing.GameLogSlug, "", "peril/game_logs/_"},
+	}
+	for _, tt := range tests {
+		got := Topic("peril", tt.prefix, tt.key)
+		if got != tt.want {
+			t.Errorf("Topic(%q, %q) = %q, want %q", tt.prefix, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestTopicLevel(t *testing.T) {
+	for _, s := range []string{"a/b", "+", "#", "a+#/b", "\x00"} {
+		got := topicLevel(s)
+		if strings.ContainsAny(got, "/+#\x00") {
+			t.Errorf("topicLevel(%q) = %q still has a separator or wildcard", s, got)
+		}
+	}
+	if got := topicLevel("bob"); got != "bob" {
+		t.Errorf("topicLevel(%q) = %q, want it unchanged", "bob", got)
+	}
+}
+
+func TestSanitizeMessage(t *testing.T) {
+	tests := []struct {
+		msg, want string
+	}{
+		{"hello", "hello"},
+		{"  padded \n", "padded"},
+		{"bell\x07 and\x1b[31m escape", "bell and[31m escape"},
+		{"line\nbreak", "linebreak"},
+	}
+	for _, tt := range tests {
+		got := sanitizeMessage(tt.msg)
+		if got != tt.want {
+			t.Errorf("sanitizeMessage(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+
+	long := sanitizeMessage(strings.Repeat("é", 500))
+	if n := len([]rune(long)); n != maxMessageLength {
+		t.Errorf("long message cut to %d runes, want %d", n, maxMessageLength)
+	}
+	if !strings.HasSuffix(long, "…") {
+		t.Errorf("long message %q doesn't end in an ellipsis", long)
+	}
+}
+
+// spectator collects everything published below a topic filter.
+type spectator struct {
+	mu       sync.Mutex
+	messages map[string][]byte
+	arrived  chan string
+}
+
+func newSpectator(t *testing.T, url, filter string) *spectator {
+	t.Helper()
+	s := &spectator{
+		messages: map[string][]byte{},
+		arrived:  make(chan string, 16),
+	}
+	client := connectMQTT(t, url, "spectator")
+	token := client.Subscribe(filter, 1, func(_ mqtt.Client, m mqtt.Message) {
+		s.mu.Lock()
+		s.messages[m.Topic()] = m.Payload()
+		s.mu.Unlock()
+		s.arrived <- m.Topic()
+	})
+	if !token.WaitTimeout(testTimeout) || token.Error() != nil {
+		t.Fatalf("error subscribing to %s: %v", filter, token.Error())
+	}
+	return s
+}
+
+// await waits for a message on topic and decodes it into v.
+func (s *spectator) await(t *testing.T, topic string, v any) {
+	t.Helper()
+	deadline := time.After(testTimeout)
+	for {
+		s.mu.Lock()
+		payload, ok := s.messages[topic]
+		s.mu.Unlock()
+		if ok {
+			err := json.Unmarshal(payload, v)
+			if err != nil {
+				t.Fatalf("error decoding %s: %v", topic, err)
+			}
+			return
+		}
+		select {
+		case <-s.arrived:
+		case <-deadline:
+			t.Fatalf("nothing published to %s", topic)
+		}
+	}
+}
+
+func connectMQTT(t *testing.T, url, id string) mqtt.Client {
+	t.Helper()
+	client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(url).SetClientID(id))
+	token := client.Connect()
+	if !token.WaitTimeout(testTimeout) || token.Error() != nil {
+		t.Fatalf("error connecting %s: %v", id, token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(10) })
+	return client
+}
+
+func TestBridge(t *testing.T) {
+	srv, err := mqtttest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	conn := pubsub.NewMemoryBroker().Connect()
+	t.Cleanup(func() { conn.Close() })
+	err = topology.Peril().Apply(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := New("test", connectMQTT(t, srv.URL(), "bridge"), 1, Rules("peril"))
+	subs, err := b.Start(conn)
+	t.Cleanup(func() { pubsub.CloseAll(context.Background(), subs...) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	spectator := newSpectator(t, srv.URL(), "peril/#")
+
+	ctx := context.Background()
+	publish := func(exchange, key string, msg any) {
+		t.Helper()
+		err := pubsub.Publish(ctx, conn, pubsub.JSON, exchange, key, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	publish(routing.ExchangePerilDirect, routing.PauseKey, routing.PlayingState{IsPaused: true})
+	pause := PauseEvent{}
+	spectator.await(t, "peril/pause", &pause)
+	if !pause.Paused {
+		t.Errorf("got %+v, want paused", pause)
+	}
+	if _, ok := srv.Retained("peril/pause"); !ok {
+		t.Error("pause was not retained")
+	}
+
+	publish(routing.ExchangePerilTopic, routing.WorldDeltasPrefix+".alice", gamelogic.StateDelta{
+		Seq:    3,
+		Intent: gamelogic.IntentMove,
+		Changes: []gamelogic.UnitChange{
+			{Username: "alice", Unit: gamelogic.Unit{ID: 1, Rank: gamelogic.RankInfantry, Location: "europe"}},
+			{Username: "bob", Unit: gamelogic.Unit{ID: 2, Rank: gamelogic.RankCavalry, Location: "europe"}, Removed: true},
+		},
+		Wars: []gamelogic.CombatReport{
+			{Location: "europe", Attacker: "alice", Defender: "bob", Winner: "alice", DefenderLosses: []gamelogic.Unit{{ID: 2}}},
+		},
+	})
+	army := ArmyEvent{}
+	spectator.await(t, "peril/army_moves/alice", &army)
+	if army.Seq != 3 || len(army.Units) != 1 || army.Units[0].Location != "europe" {
+		t.Errorf("got %+v for alice", army)
+	}
+	army = ArmyEvent{}
+	spectator.await(t, "peril/army_moves/bob", &army)
+	if len(army.Units) != 1 || !army.Units[0].Removed {
+		t.Errorf("got %+v for bob", army)
+	}
+	war := WarEvent{}
+	spectator.await(t, "peril/war/alice", &war)
+	if war.Winner != "alice" || war.Defender != "bob" || war.DefenderLosses != 1 {
+		t.Errorf("got %+v", war)
+	}
+
+	publish(routing.ExchangePerilTopic, routing.GameLogSlug+".carol", routing.GameLog{
+		Username: "carol",
+		Message:  "hi\x1b[2J there",
+	})
+	log := LogEvent{}
+	spectator.await(t, "peril/game_logs/carol", &log)
+	if log.Player != "carol" || log.Message != "hi[2J there" {
+		t.Errorf("got %+v", log)
+	}
+}
+
+func TestBridgeDropsRejectedCommands(t *testing.T) {
+	rules := Rules("peril")
+	var deltas Rule
+	for _, rule := range rules {
+		if rule.Name == routing.WorldDeltasPrefix {
+			deltas = rule
+		}
+	}
+	body, err := json.Marshal(gamelogic.StateDelta{
+		Username: "alice",
+		Error:    "not enough gold",
+		Changes:  []gamelogic.UnitChange{{Username: "alice"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := deltas.Translate(pubsub.Delivery{ContentType: pubsub.JSON.ContentType(), Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("got %+v for a rejected command, want nothing", events)
+	}
+}
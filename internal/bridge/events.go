@@ -0,0 +1,106 @@
+package bridge
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+// maxMessageLength caps log messages, which players write themselves.
+const maxMessageLength = 200
+
+// The events spectators get, as JSON. They carry what everyone at the table
+// could see and nothing else: no treasuries, no rejected commands.
+
+type PauseEvent struct {
+	Paused bool
+}
+
+// ArmyEvent lists one player's units that a spawn, move, turn or upkeep
+// changed.
+type ArmyEvent struct {
+	Seq    uint64
+	Player string
+	Cause  gamelogic.IntentKind
+	Units  []UnitEvent
+}
+
+type UnitEvent struct {
+	ID       int
+	Rank     gamelogic.UnitRank
+	Location gamelogic.Location
+	Removed  bool `json:",omitempty"`
+}
+
+type WarEvent struct {
+	Seq            uint64
+	Location       gamelogic.Location
+	Attacker       string
+	Defender       string
+	Winner         string `json:",omitempty"`
+	Draw           bool   `json:",omitempty"`
+	AttackerLosses int
+	DefenderLosses int
+}
+
+type LogEvent struct {
+	Time    time.Time
+	Player  string
+	Message string
+}
+
+// armyEvents splits the delta's changes by player.
+func armyEvents(delta gamelogic.StateDelta) []ArmyEvent {
+	events := []ArmyEvent{}
+	index := map[string]int{}
+	for _, change := range delta.Changes {
+		i, ok := index[change.Username]
+		if !ok {
+			i = len(events)
+			index[change.Username] = i
+			events = append(events, ArmyEvent{
+				Seq:    delta.Seq,
+				Player: change.Username,
+				Cause:  delta.Intent,
+			})
+		}
+		events[i].Units = append(events[i].Units, UnitEvent{
+			ID:       change.Unit.ID,
+			Rank:     change.Unit.Rank,
+			Location: change.Unit.Location,
+			Removed:  change.Removed,
+		})
+	}
+	return events
+}
+
+func warEvent(seq uint64, war gamelogic.CombatReport) WarEvent {
+	return WarEvent{
+		Seq:            seq,
+		Location:       war.Location,
+		Attacker:       war.Attacker,
+		Defender:       war.Defender,
+		Winner:         war.Winner,
+		Draw:           war.Draw,
+		AttackerLosses: len(war.AttackerLosses),
+		DefenderLosses: len(war.DefenderLosses),
+	}
+}
+
+// sanitizeMessage drops control characters and cuts the message short, so a
+// spammer can't flood a small screen.
+func sanitizeMessage(msg string) string {
+	msg = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, msg)
+	runes := []rune(strings.TrimSpace(msg))
+	if len(runes) > maxMessageLength {
+		return string(runes[:maxMessageLength-1]) + "…"
+	}
+	return string(runes)
+}
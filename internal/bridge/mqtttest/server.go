@@ -0,0 +1,266 @@
+// Package mqtttest runs a small MQTT 3.1.1 broker in-process, for exercising
+// the bridge without a real one.
+package mqtttest
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// Server supports QoS 0 and 1, wildcards and retained messages. Sessions
+// are always clean and there are no wills.
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	clients  map[*client]struct{}
+	retained map[string]*packets.PublishPacket
+}
+
+type client struct {
+	server *Server
+	conn   net.Conn
+
+	writeMu sync.Mutex
+	nextID  uint16
+
+	// guarded by server.mu
+	filters map[string]byte
+}
+
+// NewServer starts a broker on a random local port.
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listener: l,
+		clients:  map[*client]struct{}{},
+		retained: map[string]*packets.PublishPacket{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// URL is what paho's AddBroker takes.
+func (s *Server) URL() string {
+	return "tcp://" + s.listener.Addr().String()
+}
+
+// Retained returns the payload retained on topic.
+func (s *Server) Retained(topic string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.retained[topic]
+	if !ok {
+		return nil, false
+	}
+	return p.Payload, true
+}
+
+// Close stops accepting connections and drops the open ones.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &client{
+			server:  s,
+			conn:    conn,
+			filters: map[string]byte{},
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			c.serve()
+			s.mu.Lock()
+			delete(s.clients, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (c *client) serve() {
+	p, err := packets.ReadPacket(c.conn)
+	if err != nil {
+		return
+	}
+	connect, ok := p.(*packets.ConnectPacket)
+	if !ok {
+		return
+	}
+	connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	connack.ReturnCode = connect.Validate()
+	c.write(connack)
+	if connack.ReturnCode != packets.Accepted {
+		return
+	}
+	c.server.mu.Lock()
+	c.server.clients[c] = struct{}{}
+	c.server.mu.Unlock()
+
+	for {
+		p, err := packets.ReadPacket(c.conn)
+		if err != nil {
+			return
+		}
+		switch p := p.(type) {
+		case *packets.PublishPacket:
+			if p.Qos > 1 {
+				return
+			}
+			if p.Qos == 1 {
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				c.write(puback)
+			}
+			c.server.publish(p)
+		case *packets.SubscribePacket:
+			c.subscribe(p)
+		case *packets.UnsubscribePacket:
+			c.server.mu.Lock()
+			for _, topic := range p.Topics {
+				delete(c.filters, topic)
+			}
+			c.server.mu.Unlock()
+			unsuback := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			unsuback.MessageID = p.MessageID
+			c.write(unsuback)
+		case *packets.PingreqPacket:
+			c.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func (c *client) subscribe(p *packets.SubscribePacket) {
+	suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+	suback.MessageID = p.MessageID
+	c.server.mu.Lock()
+	retained := []*packets.PublishPacket{}
+	for i, filter := range p.Topics {
+		qos := min(p.Qoss[i], 1)
+		if validFilter(filter) {
+			c.filters[filter] = qos
+		} else {
+			qos = 0x80
+		}
+		suback.ReturnCodes = append(suback.ReturnCodes, qos)
+		for topic, msg := range c.server.retained {
+			if qos != 0x80 && matches(filter, topic) {
+				retained = append(retained, msg)
+			}
+		}
+	}
+	c.server.mu.Unlock()
+
+	c.write(suback)
+	for _, msg := range retained {
+		c.deliver(msg, 1, true)
+	}
+}
+
+func (s *Server) publish(p *packets.PublishPacket) {
+	s.mu.Lock()
+	if p.Retain {
+		if len(p.Payload) == 0 {
+			delete(s.retained, p.TopicName)
+		} else {
+			s.retained[p.TopicName] = p.Copy()
+		}
+	}
+	type target struct {
+		c   *client
+		qos byte
+	}
+	targets := []target{}
+	for c := range s.clients {
+		best, ok := byte(0), false
+		for filter, qos := range c.filters {
+			if matches(filter, p.TopicName) {
+				best, ok = max(best, qos), true
+			}
+		}
+		if ok {
+			targets = append(targets, target{c, best})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, t := range targets {
+		t.c.deliver(p, t.qos, false)
+	}
+}
+
+func (c *client) deliver(p *packets.PublishPacket, qos byte, retain bool) {
+	msg := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	msg.TopicName = p.TopicName
+	msg.Payload = p.Payload
+	msg.Qos = min(p.Qos, qos)
+	msg.Retain = retain
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if msg.Qos > 0 {
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		msg.MessageID = c.nextID
+	}
+	msg.Write(c.conn)
+}
+
+func (c *client) write(p packets.ControlPacket) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return p.Write(c.conn)
+}
+
+// validFilter checks that wildcards take up whole levels and # comes last.
+func validFilter(filter string) bool {
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.ContainsAny(level, "+#") && len(level) > 1 {
+			return false
+		}
+		if level == "#" && i != len(levels)-1 {
+			return false
+		}
+	}
+	return filter != ""
+}
+
+func matches(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) || level != "+" && level != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
@@ -0,0 +1,264 @@
+package gamelogic
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// CombatResolver decides a war between the units two players have at a
+// location.
+type CombatResolver interface {
+	Resolve(rw RecognitionOfWar, location Location) CombatReport
+}
+
+// CombatReport is the outcome of a war. Losses are the units each side has
+// to remove, which is not necessarily all of them. The loser's other units
+// retreat, which the world fills in: Retreated are the units that fell back
+// to RetreatTo.
+type CombatReport struct {
+	Location       Location
+	Attacker       string
+	Defender       string
+	Winner         string
+	Loser          string
+	Draw           bool
+	Rounds         int
+	TerrainBonus   int
+	AttackerUnits  []Unit
+	DefenderUnits  []Unit
+	AttackerLosses []Unit
+	DefenderLosses []Unit
+	RetreatTo      Location `json:",omitempty"`
+	Retreated      []Unit   `json:",omitempty"`
+}
+
+// Survivors returns the units the given player fought with and didn't lose.
+func (r CombatReport) Survivors(username string) []Unit {
+	units := r.AttackerUnits
+	if username == r.Defender {
+		units = r.DefenderUnits
+	}
+	lost := map[int]bool{}
+	for _, unit := range r.Losses(username) {
+		lost[unit.ID] = true
+	}
+	survivors := []Unit{}
+	for _, unit := range units {
+		if !lost[unit.ID] {
+			survivors = append(survivors, unit)
+		}
+	}
+	return survivors
+}
+
+// Losses returns the units the given player lost.
+func (r CombatReport) Losses(username string) []Unit {
+	switch username {
+	case r.Attacker:
+		return r.AttackerLosses
+	case r.Defender:
+		return r.DefenderLosses
+	}
+	return nil
+}
+
+func (r CombatReport) Print(out io.Writer) {
+	fmt.Fprintf(out, "==== War in %s ====\n", r.Location)
+	fmt.Fprintf(out, "%s attacked with %s\n", r.Attacker, describeUnits(r.AttackerUnits))
+	fmt.Fprintf(out, "%s defended with %s", r.Defender, describeUnits(r.DefenderUnits))
+	if r.TerrainBonus > 0 {
+		fmt.Fprintf(out, " (+%v from the terrain)", r.TerrainBonus)
+	}
+	fmt.Fprintln(out)
+	if r.Rounds > 0 {
+		fmt.Fprintf(out, "The fighting lasted %v round(s)\n", r.Rounds)
+	}
+	fmt.Fprintf(out, "%s lost %s\n", r.Attacker, describeUnits(r.AttackerLosses))
+	fmt.Fprintf(out, "%s lost %s\n", r.Defender, describeUnits(r.DefenderLosses))
+	if len(r.Retreated) > 0 {
+		fmt.Fprintf(out, "%s retreated to %s with %s\n", r.Loser, r.RetreatTo, describeUnits(r.Retreated))
+	}
+	if r.Draw {
+		fmt.Fprintf(out, "A war between %s and %s in %s resulted in a draw\n", r.Attacker, r.Defender, r.Location)
+		return
+	}
+	fmt.Fprintf(out, "%s won a war against %s in %s\n", r.Winner, r.Loser, r.Location)
+}
+
+func describeUnits(units []Unit) string {
+	if len(units) == 0 {
+		return "nothing"
+	}
+	names := []string{}
+	for _, unit := range units {
+		names = append(names, fmt.Sprintf("%s %v", unit.Rank, unit.ID))
+	}
+	return strings.Join(names, ", ")
+}
+
+func newReport(rw RecognitionOfWar, location Location) CombatReport {
+	return CombatReport{
+		Location:      location,
+		Attacker:      rw.Attacker.Username,
+		Defender:      rw.Defender.Username,
+		AttackerUnits: sortedUnits(unitsAt(rw.Attacker, location)),
+		DefenderUnits: sortedUnits(unitsAt(rw.Defender, location)),
+	}
+}
+
+// ClassicResolver compares the summed power of both sides. The loser loses
+// every unit at the location, and a draw costs both sides everything.
+type ClassicResolver struct{}
+
+func (ClassicResolver) Resolve(rw RecognitionOfWar, location Location) CombatReport {
+	report := newReport(rw, location)
+	attackerPower := unitsToPowerLevel(report.AttackerUnits)
+	defenderPower := unitsToPowerLevel(report.DefenderUnits)
+	switch {
+	case attackerPower > defenderPower:
+		report.Winner, report.Loser = report.Attacker, report.Defender
+		report.DefenderLosses = report.DefenderUnits
+	case defenderPower > attackerPower:
+		report.Winner, report.Loser = report.Defender, report.Attacker
+		report.AttackerLosses = report.AttackerUnits
+	default:
+		report.Draw = true
+		report.AttackerLosses = report.AttackerUnits
+		report.DefenderLosses = report.DefenderUnits
+	}
+	return report
+}
+
+const (
+	diceRounds   = 3
+	diceSides    = 6
+	diceToHit    = 5
+	counterBonus = 1
+)
+
+// rankAttack is added to every roll a unit makes.
+var rankAttack = map[UnitRank]int{
+	RankInfantry:  0,
+	RankCavalry:   1,
+	RankArtillery: 2,
+}
+
+// rankCounters says which rank each rank is especially good against.
+var rankCounters = map[UnitRank]UnitRank{
+	RankCavalry:   RankArtillery,
+	RankArtillery: RankInfantry,
+	RankInfantry:  RankCavalry,
+}
+
+// DiceResolver fights a war in rounds. Every round each unit rolls a die
+// against an enemy unit, preferring ranks it counters, and kills it on a
+// high enough roll. Hits land at the end of the round, so both sides always
+// get to fire. The defender gets the terrain bonus of the location on every
+// roll. When both sides are still standing after the last round, the weaker
+// one is routed: it only loses the units that were hit, and the rest
+// retreat. A draw still costs both sides everything.
+//
+// Wars are decided by the seed and the order they are fought in, so the same
+// seed replays the same game.
+type DiceResolver struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func NewDiceResolver(seed int64) *DiceResolver {
+	return &DiceResolver{
+		rng: rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (d *DiceResolver) Resolve(rw RecognitionOfWar, location Location) CombatReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	report := newReport(rw, location)
+	report.TerrainBonus = CurrentMap().Defense(location)
+	attackers := report.AttackerUnits
+	defenders := report.DefenderUnits
+
+	for len(attackers) > 0 && len(defenders) > 0 && report.Rounds < diceRounds {
+		report.Rounds++
+		attackerHits := d.volley(attackers, defenders, 0)
+		defenderHits := d.volley(defenders, attackers, report.TerrainBonus)
+		defenders, report.DefenderLosses = removeHits(defenders, attackerHits, report.DefenderLosses)
+		attackers, report.AttackerLosses = removeHits(attackers, defenderHits, report.AttackerLosses)
+	}
+
+	attackerPower := unitsToPowerLevel(attackers)
+	defenderPower := unitsToPowerLevel(defenders)
+	switch {
+	case attackerPower > defenderPower:
+		report.Winner, report.Loser = report.Attacker, report.Defender
+	case defenderPower > attackerPower:
+		report.Winner, report.Loser = report.Defender, report.Attacker
+	default:
+		report.Draw = true
+		report.AttackerLosses = append(report.AttackerLosses, attackers...)
+		report.DefenderLosses = append(report.DefenderLosses, defenders...)
+	}
+	return report
+}
+
+// volley rolls for every shooter and returns the IDs of the targets hit.
+func (d *DiceResolver) volley(shooters, targets []Unit, bonus int) map[int]bool {
+	hits := map[int]bool{}
+	for _, shooter := range shooters {
+		target, ok := pickTarget(shooter, targets, hits)
+		if !ok {
+			break
+		}
+		roll := d.rng.Intn(diceSides) + 1 + rankAttack[shooter.Rank] + bonus
+		if rankCounters[shooter.Rank] == target.Rank {
+			roll += counterBonus
+		}
+		if roll >= diceToHit {
+			hits[target.ID] = true
+		}
+	}
+	return hits
+}
+
+func pickTarget(shooter Unit, targets []Unit, hit map[int]bool) (Unit, bool) {
+	var fallback *Unit
+	for i, target := range targets {
+		if hit[target.ID] {
+			continue
+		}
+		if rankCounters[shooter.Rank] == target.Rank {
+			return target, true
+		}
+		if fallback == nil {
+			fallback = &targets[i]
+		}
+	}
+	if fallback == nil {
+		return Unit{}, false
+	}
+	return *fallback, true
+}
+
+func removeHits(units []Unit, hits map[int]bool, losses []Unit) ([]Unit, []Unit) {
+	alive := []Unit{}
+	for _, unit := range units {
+		if hits[unit.ID] {
+			losses = append(losses, unit)
+			continue
+		}
+		alive = append(alive, unit)
+	}
+	return alive, losses
+}
+
+func sortedUnits(units []Unit) []Unit {
+	units = slices.Clone(units)
+	slices.SortFunc(units, func(a, b Unit) int { return a.ID - b.ID })
+	return units
+}
@@ -0,0 +1,126 @@
+package gamelogic
+
+import "testing"
+
+// firstHitResolver has the attacker win after hitting the defender's first
+// unit only.
+type firstHitResolver struct{}
+
+func (firstHitResolver) Resolve(rw RecognitionOfWar, location Location) CombatReport {
+	report := newReport(rw, location)
+	report.Winner, report.Loser = report.Attacker, report.Defender
+	report.DefenderLosses = report.DefenderUnits[:1]
+	return report
+}
+
+func TestDiceResolverRoutsWithoutKilling(t *testing.T) {
+	attacker := Player{Username: "alice", Units: map[int]Unit{}}
+	defender := Player{Username: "bob", Units: map[int]Unit{}}
+	for id := 1; id <= 4; id++ {
+		attacker.Units[id] = Unit{ID: id, Rank: RankArtillery, Location: "asia"}
+		defender.Units[id] = Unit{ID: id, Rank: RankInfantry, Location: "asia"}
+	}
+	rw := RecognitionOfWar{Attacker: attacker, Defender: defender}
+
+	routed := 0
+	for seed := int64(1); seed <= 200; seed++ {
+		report := NewDiceResolver(seed).Resolve(rw, "asia")
+		if report.Draw {
+			continue
+		}
+		survivors := report.Survivors(report.Loser)
+		if len(survivors)+len(report.Losses(report.Loser)) != 4 {
+			t.Fatalf("seed %d: %s lost %v and kept %v of 4 units", seed, report.Loser, report.Losses(report.Loser), survivors)
+		}
+		if len(survivors) > 0 {
+			routed++
+		}
+	}
+	if routed == 0 {
+		t.Error("no loser ever kept a unit")
+	}
+}
+
+func TestWorldRetreatsSurvivors(t *testing.T) {
+	w := NewWorld()
+	w.SetCombatResolver(firstHitResolver{})
+	for _, intent := range []Intent{
+		{Kind: IntentJoin, Username: "alice"},
+		{Kind: IntentJoin, Username: "bob"},
+		{Kind: IntentSpawn, Username: "alice", Location: "europe", Rank: RankInfantry},
+		{Kind: IntentSpawn, Username: "alice", Location: "europe", Rank: RankInfantry},
+		{Kind: IntentSpawn, Username: "bob", Location: "asia", Rank: RankInfantry},
+		{Kind: IntentSpawn, Username: "bob", Location: "asia", Rank: RankInfantry},
+	} {
+		delta := w.HandleIntent(intent)
+		if delta.Error != "" {
+			t.Fatalf("%s for %s: %s", intent.Kind, intent.Username, delta.Error)
+		}
+	}
+
+	delta := w.HandleIntent(Intent{Kind: IntentMove, Username: "alice", Location: "asia", UnitIDs: []int{1}})
+	if delta.Error != "" {
+		t.Fatal(delta.Error)
+	}
+	if len(delta.Wars) != 1 {
+		t.Fatalf("got %d wars, want 1", len(delta.Wars))
+	}
+	// Europe is alice's, so bob falls back to africa, the first free
+	// neighbor of asia.
+	war := delta.Wars[0]
+	if war.RetreatTo != "africa" || len(war.Retreated) != 1 || war.Retreated[0].ID != 2 {
+		t.Errorf("bob retreated to %q with %v", war.RetreatTo, war.Retreated)
+	}
+
+	bob := w.players["bob"].GetPlayerSnap()
+	if _, ok := bob.Units[1]; ok {
+		t.Error("bob's hit unit survived")
+	}
+	if unit, ok := bob.Units[2]; !ok || unit.Location != "africa" {
+		t.Errorf("bob's other unit is %+v, want it in africa", unit)
+	}
+	retreated := false
+	for _, change := range delta.Changes {
+		if change.Username == "bob" && change.Unit.ID == 2 && !change.Removed && change.Unit.Location == "africa" {
+			retreated = true
+		}
+	}
+	if !retreated {
+		t.Errorf("the retreat isn't in the delta's changes: %+v", delta.Changes)
+	}
+}
+
+func TestWorldSurrendersWithNowhereToGo(t *testing.T) {
+	w := NewWorld()
+	w.SetCombatResolver(firstHitResolver{})
+	intents := []Intent{
+		{Kind: IntentJoin, Username: "alice"},
+		{Kind: IntentJoin, Username: "bob"},
+		{Kind: IntentSpawn, Username: "bob", Location: "australia", Rank: RankInfantry},
+		{Kind: IntentSpawn, Username: "bob", Location: "australia", Rank: RankInfantry},
+		{Kind: IntentSpawn, Username: "alice", Location: "asia", Rank: RankInfantry},
+		{Kind: IntentSpawn, Username: "alice", Location: "asia", Rank: RankInfantry},
+		{Kind: IntentSpawn, Username: "alice", Location: "asia", Rank: RankInfantry},
+	}
+	for _, intent := range intents {
+		delta := w.HandleIntent(intent)
+		if delta.Error != "" {
+			t.Fatalf("%s for %s: %s", intent.Kind, intent.Username, delta.Error)
+		}
+	}
+	// Australia's other neighbors are asia and antarctica, and alice holds
+	// both.
+	w.players["alice"].UpdateUnit(Unit{ID: 3, Rank: RankInfantry, Location: "antarctica"})
+
+	delta := w.HandleIntent(Intent{Kind: IntentMove, Username: "alice", Location: "australia", UnitIDs: []int{1}})
+	if delta.Error != "" {
+		t.Fatal(delta.Error)
+	}
+	war := delta.Wars[0]
+	if war.RetreatTo != "" || len(war.DefenderLosses) != 2 {
+		t.Errorf("bob retreated to %q and lost %v", war.RetreatTo, war.DefenderLosses)
+	}
+	if units := w.players["bob"].GetPlayerSnap().Units; len(units) != 0 {
+		t.Errorf("bob kept %v", units)
+	}
+}
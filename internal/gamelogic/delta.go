@@ -0,0 +1,93 @@
+package gamelogic
+
+import "fmt"
+
+// HandleDelta brings the local state in line with a change the server made
+// and reports whether the local player's units or treasury changed. Only the local
+// player's units are tracked, other players' changes are just reported.
+func (gs *GameState) HandleDelta(delta StateDelta) bool {
+	username := gs.GetUsername()
+	if delta.Error != "" {
+		if delta.Username == username {
+			fmt.Fprintln(gs.out)
+			fmt.Fprintf(gs.out, "The server rejected your %s: %s\n", delta.Intent, delta.Error)
+		}
+		return false
+	}
+
+	treasury, paid := delta.Treasuries[username]
+	if paid {
+		gs.setTreasury(treasury)
+	}
+	if delta.Intent == IntentTick {
+		gs.updateTreaties(delta.Treaties, false)
+		if !paid {
+			return false
+		}
+		gs.handleTick(delta)
+		printTreaties(gs.out, username, delta.Treaties)
+		return true
+	}
+
+	defer fmt.Fprintln(gs.out, "------------------------")
+	fmt.Fprintln(gs.out)
+	if delta.Intent == IntentTurn {
+		fmt.Fprintf(gs.out, "==== Turn %v Resolved ====\n", delta.Turn)
+	}
+	if delta.Intent == IntentJoin {
+		if delta.Username != username {
+			fmt.Fprintf(gs.out, "%s joined the game\n", delta.Username)
+			return false
+		}
+		// The server's units win over whatever was restored locally.
+		units := []Unit{}
+		for _, change := range delta.Changes {
+			units = append(units, change.Unit)
+		}
+		gs.replaceUnits(units)
+		gs.updateTreaties(delta.Treaties, true)
+		fmt.Fprintf(gs.out, "The server knows %v unit(s) of yours\n", len(units))
+		printTreaties(gs.out, username, delta.Treaties)
+		return true
+	}
+	gs.updateTreaties(delta.Treaties, false)
+	printTreaties(gs.out, username, delta.Treaties)
+
+	changed := paid
+	for _, change := range delta.Changes {
+		if change.Username != username {
+			if !change.Removed {
+				fmt.Fprintf(gs.out, "%s's %s is now in %s\n", change.Username, change.Unit.Rank, change.Unit.Location)
+			}
+			continue
+		}
+		changed = true
+		switch {
+		case change.Removed:
+			gs.removeUnit(change.Unit.ID)
+			fmt.Fprintf(gs.out, "Your %s %v in %s has been killed.\n", change.Unit.Rank, change.Unit.ID, change.Unit.Location)
+		case delta.Intent == IntentSpawn || delta.Intent == IntentTurn && !gs.hasUnit(change.Unit.ID):
+			gs.UpdateUnit(change.Unit)
+			fmt.Fprintf(gs.out, "Spawned a(n) %s in %s with id %v\n", change.Unit.Rank, change.Unit.Location, change.Unit.ID)
+		default:
+			gs.UpdateUnit(change.Unit)
+			fmt.Fprintf(gs.out, "Your %s %v is in %s\n", change.Unit.Rank, change.Unit.ID, change.Unit.Location)
+		}
+	}
+	for _, war := range delta.Wars {
+		war.Print(gs.out)
+	}
+	return changed
+}
+
+func (gs *GameState) handleTick(delta StateDelta) {
+	fmt.Fprintln(gs.out)
+	for _, change := range delta.Changes {
+		if change.Username != gs.GetUsername() {
+			continue
+		}
+		gs.removeUnit(change.Unit.ID)
+		fmt.Fprintf(gs.out, "You could not pay for your %s %v in %s, it was disbanded.\n", change.Unit.Rank, change.Unit.ID, change.Unit.Location)
+	}
+	fmt.Fprintf(gs.out, "Payday! Your treasury holds %v gold.\n", gs.Treasury())
+}
@@ -0,0 +1,304 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+type TreatyKind string
+
+const (
+	TreatyAlliance TreatyKind = "alliance"
+	TreatyTruce    TreatyKind = "truce"
+)
+
+type DiplomacyAction string
+
+const (
+	DiplomacyPropose DiplomacyAction = "propose"
+	DiplomacyAccept  DiplomacyAction = "accept"
+	DiplomacyReject  DiplomacyAction = "reject"
+	DiplomacyBreak   DiplomacyAction = "break"
+)
+
+// DiplomacyMessage goes from one player to another, and past the server,
+// which only believes an accept it has seen the proposal for.
+type DiplomacyMessage struct {
+	From   string
+	To     string
+	Action DiplomacyAction
+	Treaty TreatyKind
+	Turns  int
+}
+
+// Treaty keeps two players from going to war with each other, and lets them
+// share locations. A truce ends on its own after Turns ticks, an alliance
+// lasts until one side breaks it.
+type Treaty struct {
+	Kind    TreatyKind
+	Players [2]string
+	Turns   int
+	Expires int
+	Ended   bool
+}
+
+func treatyKey(a, b string) [2]string {
+	if b < a {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+// Other returns the player on the other side of the treaty.
+func (t Treaty) Other(username string) string {
+	if t.Players[0] == username {
+		return t.Players[1]
+	}
+	return t.Players[0]
+}
+
+func (w *World) atPeace(a, b string) bool {
+	_, ok := w.treaties[treatyKey(a, b)]
+	return ok
+}
+
+// HandleDiplomacy keeps track of proposals and signs or ends treaties. It
+// only returns a delta when a treaty changed or a message was rejected.
+func (w *World) HandleDiplomacy(msg DiplomacyMessage) (StateDelta, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delta := StateDelta{
+		Username: msg.From,
+		Intent:   IntentDiplomacy,
+		Turn:     w.turn,
+	}
+	reject := func(err error) (StateDelta, bool) {
+		w.seq++
+		delta.Seq = w.seq
+		delta.Error = err.Error()
+		fmt.Fprintf(w.out, "Rejected %s from %s: %v\n", msg.Action, msg.From, err)
+		return delta, true
+	}
+	if msg.From == "" || msg.To == "" || msg.From == msg.To {
+		return reject(errors.New("diplomacy takes two different players"))
+	}
+
+	key := treatyKey(msg.From, msg.To)
+	switch msg.Action {
+	case DiplomacyPropose:
+		if msg.Treaty != TreatyAlliance && msg.Treaty != TreatyTruce {
+			return reject(fmt.Errorf("unknown treaty %q", msg.Treaty))
+		}
+		if msg.Treaty == TreatyTruce && msg.Turns < 1 {
+			return reject(errors.New("a truce has to last at least 1 turn"))
+		}
+		w.proposals[[2]string{msg.From, msg.To}] = msg
+		fmt.Fprintf(w.out, "%s proposed a(n) %s to %s\n", msg.From, msg.Treaty, msg.To)
+		return StateDelta{}, false
+	case DiplomacyReject:
+		delete(w.proposals, [2]string{msg.To, msg.From})
+		fmt.Fprintf(w.out, "%s rejected %s's proposal\n", msg.From, msg.To)
+		return StateDelta{}, false
+	case DiplomacyAccept:
+		proposal, ok := w.proposals[[2]string{msg.To, msg.From}]
+		if !ok || proposal.Treaty != msg.Treaty {
+			return reject(fmt.Errorf("%s has not proposed a(n) %s", msg.To, msg.Treaty))
+		}
+		delete(w.proposals, [2]string{msg.To, msg.From})
+		treaty := Treaty{
+			Kind:    proposal.Treaty,
+			Players: key,
+			Turns:   proposal.Turns,
+		}
+		if treaty.Kind == TreatyTruce {
+			treaty.Expires = w.ticks + proposal.Turns
+		}
+		w.treaties[key] = treaty
+		delta.Treaties = []Treaty{treaty}
+		fmt.Fprintf(w.out, "%s and %s signed a(n) %s\n", key[0], key[1], treaty.Kind)
+	case DiplomacyBreak:
+		treaty, ok := w.treaties[key]
+		if !ok {
+			return reject(fmt.Errorf("there is no treaty with %s", msg.To))
+		}
+		delete(w.treaties, key)
+		treaty.Ended = true
+		delta.Treaties = []Treaty{treaty}
+		fmt.Fprintf(w.out, "%s broke the %s with %s\n", msg.From, treaty.Kind, msg.To)
+	default:
+		return reject(fmt.Errorf("unknown diplomatic action %q", msg.Action))
+	}
+
+	w.seq++
+	delta.Seq = w.seq
+	return delta, true
+}
+
+// endTruces ends the truces that have run out by now.
+func (w *World) endTruces() []Treaty {
+	ended := []Treaty{}
+	for key, treaty := range w.treaties {
+		if treaty.Kind == TreatyTruce && treaty.Expires <= w.ticks {
+			delete(w.treaties, key)
+			treaty.Ended = true
+			ended = append(ended, treaty)
+			fmt.Fprintf(w.out, "The truce between %s and %s has ended\n", key[0], key[1])
+		}
+	}
+	return ended
+}
+
+// treatiesOf returns the treaties the player is part of.
+func (w *World) treatiesOf(username string) []Treaty {
+	treaties := []Treaty{}
+	for key, treaty := range w.treaties {
+		if key[0] == username || key[1] == username {
+			treaties = append(treaties, treaty)
+		}
+	}
+	return treaties
+}
+
+func (gs *GameState) atPeaceWith(username string) bool {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	_, ok := gs.treaties[username]
+	return ok
+}
+
+// updateTreaties applies treaty changes from the server. With reset set the
+// given treaties replace all known ones.
+func (gs *GameState) updateTreaties(treaties []Treaty, reset bool) {
+	username := gs.GetUsername()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if reset {
+		gs.treaties = map[string]Treaty{}
+	}
+	for _, treaty := range treaties {
+		if treaty.Players[0] != username && treaty.Players[1] != username {
+			continue
+		}
+		other := treaty.Other(username)
+		if treaty.Ended {
+			delete(gs.treaties, other)
+			continue
+		}
+		gs.treaties[other] = treaty
+		delete(gs.proposals, other)
+	}
+}
+
+func printTreaties(out io.Writer, username string, treaties []Treaty) {
+	for _, treaty := range treaties {
+		involved := treaty.Players[0] == username || treaty.Players[1] == username
+		switch {
+		case involved && treaty.Ended:
+			fmt.Fprintf(out, "Your %s with %s has ended.\n", treaty.Kind, treaty.Other(username))
+		case involved && treaty.Kind == TreatyTruce:
+			fmt.Fprintf(out, "You are in a truce with %s for %v turn(s).\n", treaty.Other(username), treaty.Turns)
+		case involved:
+			fmt.Fprintf(out, "You are allied with %s.\n", treaty.Other(username))
+		case treaty.Ended:
+			fmt.Fprintf(out, "The %s between %s and %s has ended.\n", treaty.Kind, treaty.Players[0], treaty.Players[1])
+		default:
+			fmt.Fprintf(out, "%s and %s signed a(n) %s.\n", treaty.Players[0], treaty.Players[1], treaty.Kind)
+		}
+	}
+}
+
+// HandleDiplomacy shows a message another player sent us and remembers
+// proposals, so they can be accepted.
+func (gs *GameState) HandleDiplomacy(msg DiplomacyMessage) {
+	defer fmt.Fprintln(gs.out, "------------------------")
+	fmt.Fprintln(gs.out)
+	switch msg.Action {
+	case DiplomacyPropose:
+		gs.mu.Lock()
+		gs.proposals[msg.From] = msg
+		gs.mu.Unlock()
+		if msg.Treaty == TreatyTruce {
+			fmt.Fprintf(gs.out, "%s proposes a truce for %v turn(s).\n", msg.From, msg.Turns)
+		} else {
+			fmt.Fprintf(gs.out, "%s proposes an alliance.\n", msg.From)
+		}
+		fmt.Fprintf(gs.out, "Type `accept %s` or `reject %s`.\n", msg.From, msg.From)
+	case DiplomacyReject:
+		fmt.Fprintf(gs.out, "%s rejected your proposal.\n", msg.From)
+	case DiplomacyAccept:
+		fmt.Fprintf(gs.out, "%s accepted your proposal.\n", msg.From)
+	case DiplomacyBreak:
+		fmt.Fprintf(gs.out, "%s broke your treaty.\n", msg.From)
+	}
+}
+
+// CommandDiplomacy turns ally, truce, break, accept and reject commands into
+// a message for the other player.
+func (gs *GameState) CommandDiplomacy(words []string) (DiplomacyMessage, error) {
+	if len(words) < 2 {
+		return DiplomacyMessage{}, fmt.Errorf("usage: %s <user>", words[0])
+	}
+	msg := DiplomacyMessage{
+		From: gs.GetUsername(),
+		To:   words[1],
+	}
+	if msg.To == msg.From {
+		return DiplomacyMessage{}, errors.New("error: you can't make treaties with yourself")
+	}
+
+	switch words[0] {
+	case "ally":
+		msg.Action, msg.Treaty = DiplomacyPropose, TreatyAlliance
+	case "truce":
+		if len(words) < 3 {
+			return DiplomacyMessage{}, errors.New("usage: truce <user> <turns>")
+		}
+		turns, err := strconv.Atoi(words[2])
+		if err != nil || turns < 1 {
+			return DiplomacyMessage{}, fmt.Errorf("error: %s is not a valid number of turns", words[2])
+		}
+		msg.Action, msg.Treaty, msg.Turns = DiplomacyPropose, TreatyTruce, turns
+	case "break":
+		if !gs.atPeaceWith(msg.To) {
+			return DiplomacyMessage{}, fmt.Errorf("error: you have no treaty with %s", msg.To)
+		}
+		msg.Action = DiplomacyBreak
+	case "accept", "reject":
+		gs.mu.Lock()
+		proposal, ok := gs.proposals[msg.To]
+		delete(gs.proposals, msg.To)
+		gs.mu.Unlock()
+		if !ok {
+			return DiplomacyMessage{}, fmt.Errorf("error: %s has not proposed anything", msg.To)
+		}
+		msg.Action, msg.Treaty, msg.Turns = DiplomacyAccept, proposal.Treaty, proposal.Turns
+		if words[0] == "reject" {
+			msg.Action = DiplomacyReject
+		}
+	default:
+		return DiplomacyMessage{}, fmt.Errorf("error: unknown diplomacy command %s", words[0])
+	}
+	return msg, nil
+}
+
+func (gs *GameState) printTreaties() {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	others := []string{}
+	for other := range gs.treaties {
+		others = append(others, other)
+	}
+	slices.Sort(others)
+	for _, other := range others {
+		treaty := gs.treaties[other]
+		if treaty.Kind == TreatyTruce {
+			fmt.Fprintf(gs.out, "* truce with %s\n", other)
+			continue
+		}
+		fmt.Fprintf(gs.out, "* alliance with %s\n", other)
+	}
+}
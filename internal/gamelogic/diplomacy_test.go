@@ -0,0 +1,73 @@
+package gamelogic
+
+import (
+	"io"
+	"testing"
+)
+
+func TestAllianceSharesLocations(t *testing.T) {
+	w := NewWorld()
+	w.SetOutput(io.Discard)
+	w.SetCombatResolver(ClassicResolver{})
+	handle := func(intent Intent) StateDelta {
+		t.Helper()
+		delta := w.HandleIntent(intent)
+		if delta.Error != "" {
+			t.Fatal(delta.Error)
+		}
+		return delta
+	}
+	handle(Intent{Kind: IntentSpawn, Username: "alice", Location: "europe", Rank: RankInfantry})
+	handle(Intent{Kind: IntentSpawn, Username: "bob", Location: "asia", Rank: RankCavalry})
+	handle(Intent{Kind: IntentSpawn, Username: "bob", Location: "asia", Rank: RankInfantry})
+
+	delta, _ := w.HandleDiplomacy(DiplomacyMessage{From: "bob", To: "alice", Action: DiplomacyAccept, Treaty: TreatyAlliance})
+	if delta.Error == "" {
+		t.Error("signed an alliance nobody proposed")
+	}
+	_, changed := w.HandleDiplomacy(DiplomacyMessage{From: "alice", To: "bob", Action: DiplomacyPropose, Treaty: TreatyAlliance})
+	if changed {
+		t.Error("a proposal changed the world")
+	}
+	delta, _ = w.HandleDiplomacy(DiplomacyMessage{From: "bob", To: "alice", Action: DiplomacyAccept, Treaty: TreatyAlliance})
+	if delta.Error != "" || len(delta.Treaties) != 1 || delta.Treaties[0].Kind != TreatyAlliance {
+		t.Fatalf("got %+v, want a signed alliance", delta)
+	}
+
+	// Allies move in with each other without a fight.
+	delta = handle(Intent{Kind: IntentMove, Username: "bob", Location: "europe", UnitIDs: []int{1}})
+	if len(delta.Changes) != 1 {
+		t.Errorf("got changes %+v, want just the move", delta.Changes)
+	}
+
+	delta, _ = w.HandleDiplomacy(DiplomacyMessage{From: "alice", To: "bob", Action: DiplomacyBreak})
+	if len(delta.Treaties) != 1 || !delta.Treaties[0].Ended {
+		t.Fatalf("got %+v, want the alliance ended", delta)
+	}
+	delta = handle(Intent{Kind: IntentMove, Username: "bob", Location: "europe", UnitIDs: []int{2}})
+	if len(w.player("alice").GetPlayerSnap().Units) != 0 {
+		t.Errorf("alice kept her units after losing a war, got changes %+v", delta.Changes)
+	}
+}
+
+func TestTruceExpires(t *testing.T) {
+	w := NewWorld()
+	w.SetOutput(io.Discard)
+	delta, _ := w.HandleDiplomacy(DiplomacyMessage{From: "alice", To: "bob", Action: DiplomacyPropose, Treaty: TreatyTruce})
+	if delta.Error == "" {
+		t.Error("proposed a truce that lasts no turns")
+	}
+	w.HandleDiplomacy(DiplomacyMessage{From: "alice", To: "bob", Action: DiplomacyPropose, Treaty: TreatyTruce, Turns: 2})
+	w.HandleDiplomacy(DiplomacyMessage{From: "bob", To: "alice", Action: DiplomacyAccept, Treaty: TreatyTruce})
+	if !w.atPeace("alice", "bob") {
+		t.Fatal("no truce after accepting")
+	}
+
+	if delta, _ := w.Tick(); len(delta.Treaties) != 0 || !w.atPeace("alice", "bob") {
+		t.Errorf("truce ended after one tick: %+v", delta.Treaties)
+	}
+	delta, _ = w.Tick()
+	if len(delta.Treaties) != 1 || !delta.Treaties[0].Ended || w.atPeace("alice", "bob") {
+		t.Errorf("truce still on after two ticks: %+v", delta.Treaties)
+	}
+}
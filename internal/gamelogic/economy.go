@@ -0,0 +1,134 @@
+package gamelogic
+
+import (
+	"fmt"
+	"slices"
+)
+
+// startingTreasury is what every new player gets to build a first army.
+const startingTreasury = 20
+
+// rankCost is paid once when a unit is spawned.
+var rankCost = map[UnitRank]int{
+	RankInfantry:  3,
+	RankCavalry:   6,
+	RankArtillery: 8,
+}
+
+// rankUpkeep is paid for every unit on every tick.
+var rankUpkeep = map[UnitRank]int{
+	RankInfantry:  1,
+	RankCavalry:   2,
+	RankArtillery: 2,
+}
+
+func SpawnCost(rank UnitRank) int {
+	return rankCost[rank]
+}
+
+// Income is what a player earns and pays on a tick.
+type Income struct {
+	Locations map[Location]int
+	Upkeep    map[UnitRank]int
+	Net       int
+}
+
+func (gs *GameState) Treasury() int {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.treasury
+}
+
+func (gs *GameState) setTreasury(treasury int) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.treasury = treasury
+}
+
+// holds reports whether the player has units at the location. Only players
+// at peace share a location, so that makes it theirs, or theirs together.
+func (gs *GameState) holds(location Location) bool {
+	return len(unitsAt(gs.GetPlayerSnap(), location)) > 0
+}
+
+func (gs *GameState) Income() Income {
+	m := CurrentMap()
+	income := Income{
+		Locations: map[Location]int{},
+		Upkeep:    map[UnitRank]int{},
+	}
+	for _, unit := range gs.getUnitsSnap() {
+		if _, ok := income.Locations[unit.Location]; !ok {
+			income.Locations[unit.Location] = m.Income(unit.Location)
+			income.Net += m.Income(unit.Location)
+		}
+		income.Upkeep[unit.Rank] += rankUpkeep[unit.Rank]
+		income.Net -= rankUpkeep[unit.Rank]
+	}
+	return income
+}
+
+// collect pays the player's income and upkeep for one tick. Units the
+// treasury can't pay for are disbanded, newest first, and returned. Income
+// is paid for what the player still holds after that: a location whose last
+// unit was disbanded earns nothing.
+func (gs *GameState) collect() []Unit {
+	treasury := gs.Treasury()
+	disbanded := []Unit{}
+	units := sortedUnits(gs.getUnitsSnap())
+	slices.Reverse(units)
+	for _, unit := range units {
+		if treasury+gs.Income().Net >= 0 {
+			break
+		}
+		gs.removeUnit(unit.ID)
+		disbanded = append(disbanded, unit)
+	}
+	gs.setTreasury(max(treasury+gs.Income().Net, 0))
+	return disbanded
+}
+
+// Tick pays every player's income and upkeep, and ends the truces that have
+// run out. Nothing happens while the game is paused.
+func (w *World) Tick() (StateDelta, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paused {
+		return StateDelta{}, false
+	}
+
+	w.seq++
+	delta := StateDelta{
+		Seq:        w.seq,
+		Intent:     IntentTick,
+		Turn:       w.turn,
+		Treasuries: map[string]int{},
+	}
+	for name, gs := range w.players {
+		for _, unit := range gs.collect() {
+			fmt.Fprintf(w.out, "%s could not pay for %s %v, it was disbanded\n", name, unit.Rank, unit.ID)
+			delta.Changes = append(delta.Changes, UnitChange{Username: name, Unit: unit, Removed: true})
+		}
+		delta.Treasuries[name] = gs.Treasury()
+	}
+	w.ticks++
+	delta.Treaties = w.endTruces()
+	return delta, true
+}
+
+func (gs *GameState) printEconomy() {
+	income := gs.Income()
+	fmt.Fprintf(gs.out, "Your treasury holds %v gold, and you make %+d per tick.\n", gs.Treasury(), income.Net)
+	for _, loc := range CurrentMap().Locations() {
+		if gold, ok := income.Locations[loc]; ok {
+			fmt.Fprintf(gs.out, "  + %v from %s\n", gold, loc)
+		}
+	}
+	for _, rank := range []UnitRank{RankInfantry, RankCavalry, RankArtillery} {
+		if gold, ok := income.Upkeep[rank]; ok {
+			fmt.Fprintf(gs.out, "  - %v upkeep for %s\n", gold, rank)
+		}
+	}
+	fmt.Fprintf(gs.out, "Spawning costs %v for infantry, %v for cavalry and %v for artillery.\n",
+		rankCost[RankInfantry], rankCost[RankCavalry], rankCost[RankArtillery])
+}
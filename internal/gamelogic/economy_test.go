@@ -0,0 +1,59 @@
+package gamelogic
+
+import "testing"
+
+func TestCollectAfterDisbanding(t *testing.T) {
+	gs := NewGameState("alice")
+	// Europe pays 3, antarctica 1. Three artillery cost 6 in upkeep.
+	gs.UpdateUnit(Unit{ID: 1, Rank: RankArtillery, Location: "europe"})
+	gs.UpdateUnit(Unit{ID: 2, Rank: RankArtillery, Location: "europe"})
+	gs.UpdateUnit(Unit{ID: 3, Rank: RankArtillery, Location: "antarctica"})
+	gs.setTreasury(0)
+
+	// 0 + 4 - 6 is short, so the newest unit goes, and antarctica's income
+	// with it. 0 + 3 - 4 is still short, 0 + 3 - 2 after the next one isn't.
+	disbanded := gs.collect()
+	if len(disbanded) != 2 || disbanded[0].ID != 3 || disbanded[1].ID != 2 {
+		t.Fatalf("disbanded %v, want units 3 and 2", disbanded)
+	}
+	if got := gs.Treasury(); got != 1 {
+		t.Errorf("treasury is %d, want 1", got)
+	}
+	if _, ok := gs.Income().Locations["antarctica"]; ok {
+		t.Error("antarctica still pays")
+	}
+}
+
+func TestCollectPaysEverything(t *testing.T) {
+	gs := NewGameState("alice")
+	gs.UpdateUnit(Unit{ID: 1, Rank: RankInfantry, Location: "europe"})
+	gs.setTreasury(5)
+	if disbanded := gs.collect(); len(disbanded) != 0 {
+		t.Errorf("disbanded %v", disbanded)
+	}
+	if got := gs.Treasury(); got != 7 {
+		t.Errorf("treasury is %d, want 7", got)
+	}
+}
+
+func TestVersion1SnapshotGetsStartingTreasury(t *testing.T) {
+	gs, err := NewGameStateFromSnapshot(Snapshot{
+		Version: 1,
+		Player:  Player{Username: "alice", Units: map[int]Unit{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gs.Treasury(); got != startingTreasury {
+		t.Errorf("treasury is %d, want %d", got, startingTreasury)
+	}
+
+	gs.setTreasury(3)
+	gs, err = NewGameStateFromSnapshot(gs.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gs.Treasury(); got != 3 {
+		t.Errorf("treasury is %d after a round trip, want 3", got)
+	}
+}
@@ -32,6 +32,59 @@ type RecognitionOfWar struct {
 
 type Location string
 
+type IntentKind string
+
+const (
+	IntentJoin  IntentKind = "join"
+	IntentSpawn IntentKind = "spawn"
+	IntentMove  IntentKind = "move"
+	IntentTurn  IntentKind = "turn"
+	IntentTick  IntentKind = "tick"
+
+	IntentDiplomacy IntentKind = "diplomacy"
+)
+
+// Intent is something a player asks the server to do. The server checks it
+// against the world and answers with a StateDelta.
+type Intent struct {
+	Kind     IntentKind
+	Username string
+	Location Location
+	Rank     UnitRank
+	UnitIDs  []int
+}
+
+// OrderBatch is everything a player wants to do in one turn. Submitting a
+// new batch for the same turn replaces the old one.
+type OrderBatch struct {
+	Turn     int
+	Username string
+	Orders   []Intent
+}
+
+type UnitChange struct {
+	Username string
+	Unit     Unit
+	Removed  bool
+}
+
+// StateDelta is published by the server after it has handled an Intent. Error
+// is set when the intent was rejected, and nothing changed. The outcome of a
+// whole turn is a single delta with Intent set to IntentTurn and no Username,
+// and so is an economy tick. Treasuries holds the new treasury of every
+// player whose gold changed, Treaties every treaty signed or ended.
+type StateDelta struct {
+	Seq        uint64
+	Username   string
+	Intent     IntentKind
+	Turn       int
+	Error      string
+	Changes    []UnitChange
+	Wars       []CombatReport
+	Treasuries map[string]int
+	Treaties   []Treaty
+}
+
 func getAllRanks() map[UnitRank]struct{} {
 	return map[UnitRank]struct{}{
 		RankInfantry:  {},
@@ -39,14 +92,3 @@ func getAllRanks() map[UnitRank]struct{} {
 		RankArtillery: {},
 	}
 }
-
-func getAllLocations() map[Location]struct{} {
-	return map[Location]struct{}{
-		"americas":   {},
-		"europe":     {},
-		"africa":     {},
-		"asia":       {},
-		"australia":  {},
-		"antarctica": {},
-	}
-}
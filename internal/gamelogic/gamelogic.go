@@ -7,6 +7,7 @@ import (
 	"math/rand"
 	"os"
 	"strings"
+	"time"
 )
 
 func PrintClientHelp() {
@@ -18,6 +19,14 @@ func PrintClientHelp() {
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
 	fmt.Println("* status")
+	fmt.Println("* map")
+	fmt.Println("* ally <user>")
+	fmt.Println("* truce <user> <turns>")
+	fmt.Println("* break <user>")
+	fmt.Println("* accept <user>")
+	fmt.Println("* reject <user>")
+	fmt.Println("* orders")
+	fmt.Println("* submit")
 	fmt.Println("* spam <n>")
 	fmt.Println("    example:")
 	fmt.Println("    spam 5")
@@ -25,23 +34,49 @@ func PrintClientHelp() {
 	fmt.Println("* help")
 }
 
-func ClientWelcome() (string, error) {
+// ClientWelcome asks for a username and, when there is a snapshot for it at
+// snapshotPath(username), offers to resume that session.
+func ClientWelcome(snapshotPath func(username string) string) (*GameState, error) {
 	fmt.Println("Welcome to the Peril client!")
 	fmt.Println("Please enter your username:")
 	words := GetInput()
 	if len(words) == 0 {
-		return "", errors.New("you must enter a username. goodbye")
+		return nil, errors.New("you must enter a username. goodbye")
 	}
 	username := words[0]
+
+	gs := NewGameState(username)
+	snap, err := LoadSnapshot(snapshotPath(username))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		fmt.Printf("Ignoring saved session: %v\n", err)
+	case snap.Player.Username != username:
+		fmt.Printf("Ignoring saved session for %s\n", snap.Player.Username)
+	default:
+		fmt.Printf("Found a saved session from %s with %d units. Resume it? (y/n)\n", snap.SavedAt.Format(time.DateTime), len(snap.Player.Units))
+		answer := GetInput()
+		if len(answer) > 0 && strings.HasPrefix(strings.ToLower(answer[0]), "y") {
+			gs, err = NewGameStateFromSnapshot(snap)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	fmt.Printf("Welcome, %s!\n", username)
 	PrintClientHelp()
-	return username, nil
+	return gs, nil
 }
 
 func PrintServerHelp() {
 	fmt.Println("Possible commands:")
 	fmt.Println("* pause")
 	fmt.Println("* resume")
+	fmt.Println("* logs [--user U] [--since T] [--grep text] [--limit N]")
+	fmt.Println("    example:")
+	fmt.Println("    logs --user alice --since 15m")
+	fmt.Println("    logs --grep \"won a war\"")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
@@ -78,15 +113,17 @@ func PrintQuit() {
 
 func (gs *GameState) CommandStatus() {
 	if gs.isPaused() {
-		fmt.Println("The game is paused.")
+		fmt.Fprintln(gs.out, "The game is paused.")
 		return
 	} else {
-		fmt.Println("The game is not paused.")
+		fmt.Fprintln(gs.out, "The game is not paused.")
 	}
 
 	p := gs.GetPlayerSnap()
-	fmt.Printf("You are %s, and you have %d units.\n", p.Username, len(p.Units))
+	fmt.Fprintf(gs.out, "You are %s, and you have %d units.\n", p.Username, len(p.Units))
 	for _, unit := range p.Units {
-		fmt.Printf("* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
+		fmt.Fprintf(gs.out, "* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
 	}
+	gs.printEconomy()
+	gs.printTreaties()
 }
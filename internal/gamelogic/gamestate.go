@@ -1,13 +1,28 @@
 package gamelogic
 
 import (
+	"io"
+	"os"
 	"sync"
+	"time"
 )
 
 type GameState struct {
-	Player Player
-	Paused bool
-	mu     *sync.RWMutex
+	Player     Player
+	Paused     bool
+	lastUnitID int
+	treasury   int
+	mu         *sync.RWMutex
+	out        io.Writer
+
+	// Only used by clients of a server in turn mode.
+	turn     int
+	deadline time.Time
+	orders   []Intent
+
+	// Treaties and proposals by the other player's name.
+	treaties  map[string]Treaty
+	proposals map[string]DiplomacyMessage
 }
 
 func NewGameState(username string) *GameState {
@@ -16,11 +31,21 @@ func NewGameState(username string) *GameState {
 			Username: username,
 			Units:    map[int]Unit{},
 		},
-		Paused: false,
-		mu:     &sync.RWMutex{},
+		Paused:    false,
+		treasury:  startingTreasury,
+		mu:        &sync.RWMutex{},
+		out:       os.Stdout,
+		treaties:  map[string]Treaty{},
+		proposals: map[string]DiplomacyMessage{},
 	}
 }
 
+// SetOutput sends what the game state prints for its player to out instead
+// of stdout. Call it before handling any messages.
+func (gs *GameState) SetOutput(out io.Writer) {
+	gs.out = out
+}
+
 func (gs *GameState) resumeGame() {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
@@ -55,10 +80,36 @@ func (gs *GameState) removeUnitsInLocation(loc Location) {
 	}
 }
 
+func (gs *GameState) removeUnit(id int) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	delete(gs.Player.Units, id)
+}
+
+// nextUnitID hands out unit IDs that are never reused, even after units die.
+func (gs *GameState) nextUnitID() int {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.lastUnitID++
+	return gs.lastUnitID
+}
+
 func (gs *GameState) UpdateUnit(u Unit) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
 	gs.Player.Units[u.ID] = u
+	gs.lastUnitID = max(gs.lastUnitID, u.ID)
+}
+
+// replaceUnits throws away the local units in favour of the given ones.
+func (gs *GameState) replaceUnits(units []Unit) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Player.Units = map[int]Unit{}
+	for _, u := range units {
+		gs.Player.Units[u.ID] = u
+		gs.lastUnitID = max(gs.lastUnitID, u.ID)
+	}
 }
 
 func (gs *GameState) GetUsername() string {
@@ -82,6 +133,11 @@ func (gs *GameState) GetUnit(id int) (Unit, bool) {
 	return u, ok
 }
 
+func (gs *GameState) hasUnit(id int) bool {
+	_, ok := gs.GetUnit(id)
+	return ok
+}
+
 func (gs *GameState) GetPlayerSnap() Player {
 	gs.mu.RLock()
 	defer gs.mu.RUnlock()
@@ -1,32 +1,166 @@
 package gamelogic
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
-const logsFile = "game.log"
+const LogsFile = "game_logs.jsonl"
 
-const writeToDiskSleep = 1 * time.Second
+// LogStore is an append-only file of game logs, one JSON record per line.
+// The time and username of every record are kept in memory, so queries only
+// go to disk for the records they return or grep through.
+type LogStore struct {
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	entries []logEntry
+	byUser  map[string][]int
+}
+
+type logEntry struct {
+	offset   int64
+	length   int
+	time     time.Time
+	username string
+}
+
+// LogQuery filters the logs returned by LogStore.Query. Zero values match
+// everything.
+type LogQuery struct {
+	Username string
+	Since    time.Time
+	Grep     string
+	Limit    int
+}
+
+// OpenLogStore opens the store at path, creating it if needed, and indexes
+// the records already in it. A partial record left by a crash is cut off.
+func OpenLogStore(path string) (*LogStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open logs file: %v", err)
+	}
+	s := &LogStore{
+		f:      f,
+		byUser: map[string][]int{},
+	}
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not read logs file: %v", err)
+		}
+		gamelog := routing.GameLog{}
+		if json.Unmarshal(line, &gamelog) != nil {
+			break
+		}
+		s.index(gamelog, len(line))
+	}
+	err = f.Truncate(s.size)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not repair logs file: %v", err)
+	}
+	return s, nil
+}
 
-func WriteLog(gamelog routing.GameLog) error {
-	log.Printf("received game log...")
-	time.Sleep(writeToDiskSleep)
+func (s *LogStore) index(gamelog routing.GameLog, length int) {
+	s.byUser[gamelog.Username] = append(s.byUser[gamelog.Username], len(s.entries))
+	s.entries = append(s.entries, logEntry{
+		offset:   s.size,
+		length:   length,
+		time:     gamelog.CurrentTime,
+		username: gamelog.Username,
+	})
+	s.size += int64(length)
+}
 
-	f, err := os.OpenFile(logsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+func (s *LogStore) Append(gamelog routing.GameLog) error {
+	line, err := json.Marshal(gamelog)
 	if err != nil {
-		return fmt.Errorf("could not open logs file: %v", err)
+		return fmt.Errorf("could not encode game log: %v", err)
 	}
-	defer f.Close()
+	line = append(line, '\n')
 
-	str := fmt.Sprintf("%v %v: %v\n", gamelog.CurrentTime.Format(time.RFC3339), gamelog.Username, gamelog.Message)
-	_, err = f.WriteString(str)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.WriteAt(line, s.size)
 	if err != nil {
 		return fmt.Errorf("could not write to logs file: %v", err)
 	}
+	s.index(gamelog, len(line))
 	return nil
 }
+
+// Query returns the newest logs matching q, oldest first.
+func (s *LogStore) Query(q LogQuery) ([]routing.GameLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	candidates := []int{}
+	if q.Username != "" {
+		candidates = s.byUser[q.Username]
+	} else {
+		for i := range s.entries {
+			candidates = append(candidates, i)
+		}
+	}
+
+	logs := []routing.GameLog{}
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if q.Limit > 0 && len(logs) == q.Limit {
+			break
+		}
+		entry := s.entries[candidates[i]]
+		if entry.time.Before(q.Since) {
+			continue
+		}
+		gamelog, err := s.read(entry)
+		if err != nil {
+			return nil, err
+		}
+		if q.Grep != "" && !strings.Contains(strings.ToLower(gamelog.Message), strings.ToLower(q.Grep)) {
+			continue
+		}
+		logs = append(logs, gamelog)
+	}
+
+	for i, j := 0, len(logs)-1; i < j; i, j = i+1, j-1 {
+		logs[i], logs[j] = logs[j], logs[i]
+	}
+	return logs, nil
+}
+
+func (s *LogStore) read(entry logEntry) (routing.GameLog, error) {
+	line := make([]byte, entry.length)
+	_, err := s.f.ReadAt(line, entry.offset)
+	if err != nil {
+		return routing.GameLog{}, fmt.Errorf("could not read logs file: %v", err)
+	}
+	gamelog := routing.GameLog{}
+	err = json.Unmarshal(line, &gamelog)
+	if err != nil {
+		return routing.GameLog{}, fmt.Errorf("could not decode game log: %v", err)
+	}
+	return gamelog, nil
+}
+
+func (s *LogStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,230 @@
+package gamelogic
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+//go:embed maps/default.json
+var defaultMapData []byte
+
+// WorldMap is the board: which locations exist, which of them border each
+// other, what each is worth and how hard it is to take, and how many borders
+// each rank can cross in one move.
+type WorldMap struct {
+	locations []Location
+	neighbors map[Location][]Location
+	income    map[Location]int
+	defense   map[Location]int
+	ranges    map[UnitRank]int
+}
+
+type mapFile struct {
+	Locations []Location       `json:"locations"`
+	Borders   [][2]Location    `json:"borders"`
+	Income    map[Location]int `json:"income"`
+	Defense   map[Location]int `json:"defense"`
+	Ranges    map[UnitRank]int `json:"ranges"`
+}
+
+var activeMap atomic.Pointer[WorldMap]
+
+func init() {
+	m, err := ParseMap(defaultMapData)
+	if err != nil {
+		panic(fmt.Sprintf("invalid default map: %v", err))
+	}
+	activeMap.Store(m)
+}
+
+// CurrentMap is the map every command and intent is checked against.
+func CurrentMap() *WorldMap {
+	return activeMap.Load()
+}
+
+// SetMap replaces the current map. The server and its clients have to agree
+// on the map, so it should only be called at startup.
+func SetMap(m *WorldMap) {
+	activeMap.Store(m)
+}
+
+func LoadMap(path string) (*WorldMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read map file: %v", err)
+	}
+	return ParseMap(data)
+}
+
+func ParseMap(data []byte) (*WorldMap, error) {
+	f := mapFile{}
+	err := json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode map: %v", err)
+	}
+	if len(f.Locations) == 0 {
+		return nil, errors.New("map has no locations")
+	}
+
+	m := &WorldMap{
+		neighbors: map[Location][]Location{},
+		income:    map[Location]int{},
+		defense:   map[Location]int{},
+		ranges:    map[UnitRank]int{},
+	}
+	for _, loc := range f.Locations {
+		if _, ok := m.neighbors[loc]; ok {
+			return nil, fmt.Errorf("location %s is listed twice", loc)
+		}
+		m.locations = append(m.locations, loc)
+		m.neighbors[loc] = []Location{}
+	}
+	slices.Sort(m.locations)
+
+	for _, border := range f.Borders {
+		a, b := border[0], border[1]
+		if !m.Has(a) || !m.Has(b) {
+			return nil, fmt.Errorf("border %s-%s uses an unknown location", a, b)
+		}
+		if a == b || slices.Contains(m.neighbors[a], b) {
+			continue
+		}
+		m.neighbors[a] = append(m.neighbors[a], b)
+		m.neighbors[b] = append(m.neighbors[b], a)
+	}
+	for loc := range m.neighbors {
+		slices.Sort(m.neighbors[loc])
+	}
+
+	for _, loc := range m.locations {
+		m.income[loc] = 1
+	}
+	for loc, income := range f.Income {
+		if !m.Has(loc) {
+			return nil, fmt.Errorf("income given for unknown location %s", loc)
+		}
+		if income < 0 {
+			return nil, fmt.Errorf("income of %s can not be negative", loc)
+		}
+		m.income[loc] = income
+	}
+	for loc, bonus := range f.Defense {
+		if !m.Has(loc) {
+			return nil, fmt.Errorf("defense given for unknown location %s", loc)
+		}
+		if bonus < 0 {
+			return nil, fmt.Errorf("defense of %s can not be negative", loc)
+		}
+		m.defense[loc] = bonus
+	}
+
+	ranks := getAllRanks()
+	for rank := range ranks {
+		m.ranges[rank] = 1
+	}
+	for rank, r := range f.Ranges {
+		if _, ok := ranks[rank]; !ok {
+			return nil, fmt.Errorf("range given for unknown rank %s", rank)
+		}
+		if r < 1 {
+			return nil, fmt.Errorf("%s must be able to move at least 1 border", rank)
+		}
+		m.ranges[rank] = r
+	}
+	return m, nil
+}
+
+// Locations are sorted by name.
+func (m *WorldMap) Locations() []Location {
+	return slices.Clone(m.locations)
+}
+
+func (m *WorldMap) Has(loc Location) bool {
+	_, ok := m.neighbors[loc]
+	return ok
+}
+
+func (m *WorldMap) Neighbors(loc Location) []Location {
+	return slices.Clone(m.neighbors[loc])
+}
+
+// Income is what holding the location pays every tick.
+func (m *WorldMap) Income(loc Location) int {
+	return m.income[loc]
+}
+
+// Defense is added to every roll of units defending the location.
+func (m *WorldMap) Defense(loc Location) int {
+	return m.defense[loc]
+}
+
+// Range is the number of borders a unit of the given rank can cross in one
+// move.
+func (m *WorldMap) Range(rank UnitRank) int {
+	return m.ranges[rank]
+}
+
+// Distance is the least number of borders between two locations. It reports
+// false when there is no path at all.
+func (m *WorldMap) Distance(from, to Location) (int, bool) {
+	if !m.Has(from) || !m.Has(to) {
+		return 0, false
+	}
+	dist := map[Location]int{from: 0}
+	queue := []Location{from}
+	for len(queue) > 0 {
+		loc := queue[0]
+		queue = queue[1:]
+		if loc == to {
+			return dist[loc], true
+		}
+		for _, next := range m.neighbors[loc] {
+			if _, seen := dist[next]; !seen {
+				dist[next] = dist[loc] + 1
+				queue = append(queue, next)
+			}
+		}
+	}
+	return 0, false
+}
+
+// checkMove returns an error if the unit can't reach the location in one
+// move.
+func (m *WorldMap) checkMove(unit Unit, to Location) error {
+	dist, ok := m.Distance(unit.Location, to)
+	if !ok {
+		return fmt.Errorf("error: there is no way from %s to %s", unit.Location, to)
+	}
+	if dist > m.Range(unit.Rank) {
+		return fmt.Errorf("error: %s %v can move %v border(s), %s is %v away", unit.Rank, unit.ID, m.Range(unit.Rank), to, dist)
+	}
+	return nil
+}
+
+func (gs *GameState) CommandMap() {
+	m := CurrentMap()
+	counts := map[Location]int{}
+	for _, unit := range gs.getUnitsSnap() {
+		counts[unit.Location]++
+	}
+
+	for _, loc := range m.Locations() {
+		neighbors := []string{}
+		for _, n := range m.Neighbors(loc) {
+			neighbors = append(neighbors, string(n))
+		}
+		terrain := ""
+		if m.Defense(loc) > 0 {
+			terrain = fmt.Sprintf(", defense +%v", m.Defense(loc))
+		}
+		fmt.Fprintf(gs.out, "* %s (%d units) [income %v%s] -> %s\n", loc, counts[loc], m.Income(loc), terrain, strings.Join(neighbors, ", "))
+	}
+	fmt.Fprintf(gs.out, "Units can cross %v border(s) as infantry, %v as cavalry and %v as artillery.\n",
+		m.Range(RankInfantry), m.Range(RankCavalry), m.Range(RankArtillery))
+}
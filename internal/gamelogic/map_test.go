@@ -0,0 +1,27 @@
+package gamelogic
+
+import "testing"
+
+func TestDefaultMap(t *testing.T) {
+	m := CurrentMap()
+	for _, loc := range m.Neighbors("antarctica") {
+		if loc == "americas" {
+			t.Error("antarctica borders the americas")
+		}
+	}
+	if d, ok := m.Distance("americas", "antarctica"); !ok || d != 3 {
+		t.Errorf("americas to antarctica is %d border(s), want 3", d)
+	}
+
+	seen := map[int]UnitRank{}
+	for rank := range getAllRanks() {
+		r := m.Range(rank)
+		if other, ok := seen[r]; ok {
+			t.Errorf("%s and %s both have range %d", rank, other, r)
+		}
+		seen[r] = rank
+	}
+	if m.Range(RankArtillery) >= m.Range(RankInfantry) || m.Range(RankInfantry) >= m.Range(RankCavalry) {
+		t.Errorf("ranges: artillery %d, infantry %d, cavalry %d", m.Range(RankArtillery), m.Range(RankInfantry), m.Range(RankCavalry))
+	}
+}
@@ -0,0 +1,24 @@
+package gamelogic
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetOutput(t *testing.T) {
+	world := NewWorld()
+	worldOut := &strings.Builder{}
+	world.SetOutput(worldOut)
+	delta := world.HandleIntent(Intent{Kind: IntentJoin, Username: "alice"})
+	if !strings.Contains(worldOut.String(), "alice joined the game") {
+		t.Errorf("world printed %q", worldOut.String())
+	}
+
+	gs := NewGameState("bob")
+	gsOut := &strings.Builder{}
+	gs.SetOutput(gsOut)
+	gs.HandleDelta(delta)
+	if !strings.Contains(gsOut.String(), "alice joined the game") {
+		t.Errorf("game state printed %q", gsOut.String())
+	}
+}
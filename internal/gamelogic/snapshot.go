@@ -0,0 +1,185 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// snapshotVersion is bumped whenever the snapshot layout changes in a way
+// older code can't read. Version 1 snapshots predate the treasury, so their
+// players start over with the starting treasury.
+const snapshotVersion = 2
+
+// Snapshot is a saved GameState.
+type Snapshot struct {
+	Version    int
+	SavedAt    time.Time
+	Player     Player
+	Paused     bool
+	LastUnitID int
+	Treasury   int
+}
+
+// WorldSnapshot is a saved World.
+type WorldSnapshot struct {
+	Version  int
+	SavedAt  time.Time
+	Seq      uint64
+	Paused   bool
+	Turn     int      `json:",omitempty"`
+	Ticks    int      `json:",omitempty"`
+	Treaties []Treaty `json:",omitempty"`
+	Players  []Snapshot
+}
+
+func (gs *GameState) Snapshot() Snapshot {
+	gs.mu.RLock()
+	paused, lastUnitID, treasury := gs.Paused, gs.lastUnitID, gs.treasury
+	gs.mu.RUnlock()
+	return Snapshot{
+		Version:    snapshotVersion,
+		SavedAt:    time.Now(),
+		Player:     gs.GetPlayerSnap(),
+		Paused:     paused,
+		LastUnitID: lastUnitID,
+		Treasury:   treasury,
+	}
+}
+
+func NewGameStateFromSnapshot(s Snapshot) (*GameState, error) {
+	if s.Version < 1 || s.Version > snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	gs := NewGameState(s.Player.Username)
+	gs.Paused = s.Paused
+	gs.lastUnitID = s.LastUnitID
+	if s.Version >= 2 {
+		gs.treasury = s.Treasury
+	}
+	for id, unit := range s.Player.Units {
+		gs.Player.Units[id] = unit
+		if id > gs.lastUnitID {
+			gs.lastUnitID = id
+		}
+	}
+	return gs, nil
+}
+
+func (gs *GameState) SaveSnapshot(path string) error {
+	return writeSnapshotFile(path, gs.Snapshot())
+}
+
+func LoadSnapshot(path string) (Snapshot, error) {
+	s := Snapshot{}
+	err := readSnapshotFile(path, &s)
+	return s, err
+}
+
+func (w *World) Snapshot() WorldSnapshot {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := WorldSnapshot{
+		Version: snapshotVersion,
+		SavedAt: time.Now(),
+		Seq:     w.seq,
+		Paused:  w.paused,
+		Turn:    w.turn,
+		Ticks:   w.ticks,
+	}
+	for _, treaty := range w.treaties {
+		s.Treaties = append(s.Treaties, treaty)
+	}
+	slices.SortFunc(s.Treaties, func(a, b Treaty) int {
+		return strings.Compare(a.Players[0]+" "+a.Players[1], b.Players[0]+" "+b.Players[1])
+	})
+	names := []string{}
+	for name := range w.players {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		s.Players = append(s.Players, w.players[name].Snapshot())
+	}
+	return s
+}
+
+func NewWorldFromSnapshot(s WorldSnapshot) (*World, error) {
+	if s.Version < 1 || s.Version > snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	w := NewWorld()
+	w.seq = s.Seq
+	w.paused = s.Paused
+	w.turn = s.Turn
+	w.ticks = s.Ticks
+	for _, treaty := range s.Treaties {
+		w.treaties[treatyKey(treaty.Players[0], treaty.Players[1])] = treaty
+	}
+	for _, ps := range s.Players {
+		gs, err := NewGameStateFromSnapshot(ps)
+		if err != nil {
+			return nil, err
+		}
+		w.players[gs.GetUsername()] = gs
+	}
+	return w, nil
+}
+
+func (w *World) SaveSnapshot(path string) error {
+	return writeSnapshotFile(path, w.Snapshot())
+}
+
+func LoadWorldSnapshot(path string) (WorldSnapshot, error) {
+	s := WorldSnapshot{}
+	err := readSnapshotFile(path, &s)
+	return s, err
+}
+
+// writeSnapshotFile replaces the file in one step, so a crash halfway
+// through saving never leaves a truncated snapshot behind.
+func writeSnapshotFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode snapshot: %v", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("could not create snapshot file: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("could not write snapshot file: %v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("could not write snapshot file: %v", err)
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return fmt.Errorf("could not replace snapshot file: %v", err)
+	}
+	return nil
+}
+
+// readSnapshotFile returns an error wrapping os.ErrNotExist when there is no
+// snapshot yet.
+func readSnapshotFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read snapshot file: %w", err)
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("could not decode snapshot: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package gamelogic
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGameStateSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alice.peril.json")
+	_, err := LoadSnapshot(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v loading a missing snapshot, want os.ErrNotExist", err)
+	}
+
+	gs := NewGameState("alice")
+	gs.addUnit(Unit{ID: gs.nextUnitID(), Rank: RankInfantry, Location: "europe"})
+	gs.addUnit(Unit{ID: gs.nextUnitID(), Rank: RankCavalry, Location: "asia"})
+	gs.removeUnit(2)
+	gs.Paused = true
+	err = gs.SaveSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := LoadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewGameStateFromSnapshot(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	units := restored.GetPlayerSnap().Units
+	if len(units) != 1 || units[1].Location != "europe" {
+		t.Errorf("restored units %v", units)
+	}
+	if !restored.isPaused() {
+		t.Error("restored game isn't paused")
+	}
+	// The removed unit's ID isn't handed out again.
+	if id := restored.nextUnitID(); id != 3 {
+		t.Errorf("next unit ID is %d, want 3", id)
+	}
+
+	snap.Version = snapshotVersion + 1
+	_, err = NewGameStateFromSnapshot(snap)
+	if err == nil {
+		t.Error("restored a snapshot from the future")
+	}
+}
+
+func TestWorldSnapshot(t *testing.T) {
+	w := NewWorld()
+	w.SetOutput(io.Discard)
+	w.HandleIntent(Intent{Kind: IntentJoin, Username: "alice"})
+	delta := w.HandleIntent(Intent{Kind: IntentSpawn, Username: "alice", Location: "europe", Rank: RankInfantry})
+	if delta.Error != "" {
+		t.Fatal(delta.Error)
+	}
+
+	path := filepath.Join(t.TempDir(), "world.peril.json")
+	err := w.SaveSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := LoadWorldSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewWorldFromSnapshot(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.SetOutput(io.Discard)
+
+	// Alice keeps her army and the deltas carry on where they left off.
+	delta = restored.HandleIntent(Intent{Kind: IntentSpawn, Username: "alice", Location: "europe", Rank: RankInfantry})
+	if delta.Error != "" {
+		t.Fatal(delta.Error)
+	}
+	if delta.Seq != 3 {
+		t.Errorf("delta seq is %d, want 3", delta.Seq)
+	}
+	if len(delta.Changes) != 1 || delta.Changes[0].Unit.ID != 2 {
+		t.Errorf("got changes %+v, want unit 2", delta.Changes)
+	}
+}
@@ -5,30 +5,43 @@ import (
 	"fmt"
 )
 
-func (gs *GameState) CommandSpawn(words []string) error {
+func (gs *GameState) CommandSpawn(words []string) (Intent, error) {
 	if len(words) < 3 {
-		return errors.New("usage: spawn <location> <rank>")
+		return Intent{}, errors.New("usage: spawn <location> <rank>")
 	}
 
-	locationName := words[1]
-	locations := getAllLocations()
-	if _, ok := locations[Location(locationName)]; !ok {
-		return fmt.Errorf("error: %s is not a valid location", locationName)
+	location := Location(words[1])
+	rank := UnitRank(words[2])
+	err := gs.validateSpawn(location, rank)
+	if err != nil {
+		return Intent{}, err
+	}
+
+	return Intent{
+		Kind:     IntentSpawn,
+		Username: gs.GetUsername(),
+		Location: location,
+		Rank:     rank,
+	}, nil
+}
+
+// validateSpawn checks what the player can know on their own: the spawn has
+// to be affordable and, once they have an army, in a location they hold.
+func (gs *GameState) validateSpawn(location Location, rank UnitRank) error {
+	if !CurrentMap().Has(location) {
+		return fmt.Errorf("error: %s is not a valid location", location)
 	}
 
-	rank := words[2]
 	units := getAllRanks()
-	if _, ok := units[UnitRank(rank)]; !ok {
+	if _, ok := units[rank]; !ok {
 		return fmt.Errorf("error: %s is not a valid unit", rank)
 	}
 
-	id := len(gs.getUnitsSnap()) + 1
-	gs.addUnit(Unit{
-		ID:       id,
-		Rank:     UnitRank(rank),
-		Location: Location(locationName),
-	})
-
-	fmt.Printf("Spawned a(n) %s in %s with id %v\n", rank, locationName, id)
+	if gs.Treasury() < rankCost[rank] {
+		return fmt.Errorf("error: a(n) %s costs %v gold, you have %v", rank, rankCost[rank], gs.Treasury())
+	}
+	if len(gs.getUnitsSnap()) > 0 && !gs.holds(location) {
+		return fmt.Errorf("error: you can only spawn units in locations you hold")
+	}
 	return nil
 }
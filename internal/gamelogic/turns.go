@@ -0,0 +1,203 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// StartTurn switches the world to turn mode, if it isn't already, and opens
+// the next turn for orders.
+func (w *World) StartTurn() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.turnMode = true
+	w.turn++
+	w.orders = map[string]OrderBatch{}
+	return w.turn
+}
+
+// SubmitOrders holds a player's orders until the turn is resolved.
+func (w *World) SubmitOrders(batch OrderBatch) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.turnMode {
+		return errors.New("the game is not turn based, send your commands directly")
+	}
+	if batch.Turn != w.turn {
+		return fmt.Errorf("orders are for turn %v, but this is turn %v", batch.Turn, w.turn)
+	}
+	if batch.Username == "" {
+		return errors.New("orders have no username")
+	}
+
+	moved := map[int]bool{}
+	for i, order := range batch.Orders {
+		switch order.Kind {
+		case IntentSpawn:
+		case IntentMove:
+			for _, unitID := range order.UnitIDs {
+				if moved[unitID] {
+					return fmt.Errorf("unit %v has more than one move order", unitID)
+				}
+				moved[unitID] = true
+			}
+		default:
+			return fmt.Errorf("%q is not an order", order.Kind)
+		}
+		batch.Orders[i].Username = batch.Username
+	}
+	w.orders[batch.Username] = batch
+	fmt.Fprintf(w.out, "%s submitted %v order(s) for turn %v\n", batch.Username, len(batch.Orders), w.turn)
+	return nil
+}
+
+// ResolveTurn carries out every order of the current turn at once. Spawns
+// happen first, then all units move, and only then are wars fought where
+// players ended up together. Rejected orders get a delta of their own,
+// the rest of the turn comes last as a single delta.
+func (w *World) ResolveTurn() []StateDelta {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	names := []string{}
+	for name := range w.orders {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	deltas := []StateDelta{}
+	turn := StateDelta{Intent: IntentTurn, Turn: w.turn}
+	reject := func(order Intent, err error) {
+		w.seq++
+		fmt.Fprintf(w.out, "Rejected %s from %s: %v\n", order.Kind, order.Username, err)
+		deltas = append(deltas, StateDelta{
+			Seq:      w.seq,
+			Username: order.Username,
+			Intent:   order.Kind,
+			Turn:     w.turn,
+			Error:    err.Error(),
+		})
+	}
+
+	for _, name := range names {
+		for _, order := range w.orders[name].Orders {
+			if order.Kind != IntentSpawn {
+				continue
+			}
+			err := w.spawn(w.player(name), order, &turn)
+			if err != nil {
+				reject(order, err)
+			}
+		}
+	}
+
+	type arrival struct {
+		location Location
+		player   *GameState
+	}
+	arrivals := []arrival{}
+	for _, name := range names {
+		for _, order := range w.orders[name].Orders {
+			if order.Kind != IntentMove {
+				continue
+			}
+			gs := w.player(name)
+			err := w.relocate(gs, order, &turn)
+			if err != nil {
+				reject(order, err)
+				continue
+			}
+			arrivals = append(arrivals, arrival{order.Location, gs})
+		}
+	}
+	for _, a := range arrivals {
+		w.fight(a.player, a.location, &turn)
+	}
+
+	w.seq++
+	turn.Seq = w.seq
+	deltas = append(deltas, turn)
+	w.orders = map[string]OrderBatch{}
+	fmt.Fprintf(w.out, "Resolved turn %v\n", w.turn)
+	return deltas
+}
+
+func (gs *GameState) HandleTurnStart(ts routing.TurnStart) {
+	defer fmt.Fprintln(gs.out, "------------------------")
+	gs.mu.Lock()
+	gs.turn = ts.Turn
+	gs.deadline = ts.Deadline
+	queued := len(gs.orders)
+	gs.mu.Unlock()
+
+	fmt.Fprintln(gs.out)
+	fmt.Fprintf(gs.out, "==== Turn %v ====\n", ts.Turn)
+	fmt.Fprintf(gs.out, "Submit your orders by %s. You have %v order(s) queued.\n", ts.Deadline.Format(time.TimeOnly), queued)
+}
+
+// InTurnMode reports whether the server has started a turn since we joined.
+func (gs *GameState) InTurnMode() bool {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.turn > 0
+}
+
+// QueueOrder keeps an order until the batch is submitted.
+func (gs *GameState) QueueOrder(order Intent) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if order.Kind == IntentMove {
+		for _, queued := range gs.orders {
+			for _, unitID := range order.UnitIDs {
+				if queued.Kind == IntentMove && slices.Contains(queued.UnitIDs, unitID) {
+					return fmt.Errorf("error: unit %v already has a move order", unitID)
+				}
+			}
+		}
+	}
+	gs.orders = append(gs.orders, order)
+	return nil
+}
+
+// Orders returns the queued orders as a batch for the current turn.
+func (gs *GameState) Orders() OrderBatch {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return OrderBatch{
+		Turn:     gs.turn,
+		Username: gs.Player.Username,
+		Orders:   slices.Clone(gs.orders),
+	}
+}
+
+func (gs *GameState) ClearOrders() {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.orders = nil
+}
+
+func (gs *GameState) CommandOrders() {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	if gs.turn == 0 {
+		fmt.Fprintln(gs.out, "The game is not turn based.")
+		return
+	}
+	fmt.Fprintf(gs.out, "Turn %v, orders due by %s.\n", gs.turn, gs.deadline.Format(time.TimeOnly))
+	if len(gs.orders) == 0 {
+		fmt.Fprintln(gs.out, "No orders queued.")
+		return
+	}
+	for i, order := range gs.orders {
+		switch order.Kind {
+		case IntentSpawn:
+			fmt.Fprintf(gs.out, "%v. spawn a(n) %s in %s\n", i+1, order.Rank, order.Location)
+		case IntentMove:
+			fmt.Fprintf(gs.out, "%v. move %v to %s\n", i+1, order.UnitIDs, order.Location)
+		}
+	}
+}
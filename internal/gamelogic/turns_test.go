@@ -0,0 +1,99 @@
+package gamelogic
+
+import (
+	"io"
+	"testing"
+)
+
+func TestResolveTurn(t *testing.T) {
+	w := NewWorld()
+	w.SetOutput(io.Discard)
+	for _, spawn := range []Intent{
+		{Kind: IntentSpawn, Username: "alice", Location: "europe", Rank: RankInfantry},
+		{Kind: IntentSpawn, Username: "bob", Location: "asia", Rank: RankInfantry},
+	} {
+		if delta := w.HandleIntent(spawn); delta.Error != "" {
+			t.Fatal(delta.Error)
+		}
+	}
+
+	err := w.SubmitOrders(OrderBatch{Turn: 1, Username: "alice"})
+	if err == nil {
+		t.Error("took orders before the first turn")
+	}
+	turn := w.StartTurn()
+	err = w.SubmitOrders(OrderBatch{Turn: turn + 1, Username: "alice"})
+	if err == nil {
+		t.Error("took orders for the wrong turn")
+	}
+	err = w.SubmitOrders(OrderBatch{Turn: turn, Username: "alice", Orders: []Intent{
+		{Kind: IntentMove, Location: "asia", UnitIDs: []int{1}},
+		{Kind: IntentMove, Location: "africa", UnitIDs: []int{1}},
+	}})
+	if err == nil {
+		t.Error("took two moves for the same unit")
+	}
+
+	// Alice and Bob swap places. Both move before anyone fights, so they
+	// never meet.
+	err = w.SubmitOrders(OrderBatch{Turn: turn, Username: "alice", Orders: []Intent{
+		{Kind: IntentMove, Location: "asia", UnitIDs: []int{1}},
+		{Kind: IntentSpawn, Location: "americas", Rank: RankInfantry},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.SubmitOrders(OrderBatch{Turn: turn, Username: "bob", Orders: []Intent{
+		{Kind: IntentMove, Location: "europe", UnitIDs: []int{1}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deltas := w.ResolveTurn()
+	if len(deltas) != 2 {
+		t.Fatalf("got %d deltas, want a rejected spawn and the turn", len(deltas))
+	}
+	if rejected := deltas[0]; rejected.Username != "alice" || rejected.Intent != IntentSpawn || rejected.Error == "" {
+		t.Errorf("got %+v, want alice's spawn rejected", rejected)
+	}
+	resolved := deltas[1]
+	if resolved.Intent != IntentTurn || resolved.Turn != turn || len(resolved.Changes) != 2 {
+		t.Errorf("got turn delta %+v", resolved)
+	}
+	for name, want := range map[string]Location{"alice": "asia", "bob": "europe"} {
+		units := w.player(name).GetPlayerSnap().Units
+		if len(units) != 1 || units[1].Location != want {
+			t.Errorf("%s has units %v, want one in %s", name, units, want)
+		}
+	}
+
+	// Orders don't carry over to the next turn.
+	w.StartTurn()
+	if deltas := w.ResolveTurn(); len(deltas) != 1 || len(deltas[0].Changes) != 0 {
+		t.Errorf("got %+v for a turn without orders", deltas)
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	gs := NewGameState("alice")
+	err := gs.QueueOrder(Intent{Kind: IntentMove, Location: "asia", UnitIDs: []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gs.QueueOrder(Intent{Kind: IntentMove, Location: "africa", UnitIDs: []int{2}})
+	if err == nil {
+		t.Error("queued a second move for unit 2")
+	}
+	err = gs.QueueOrder(Intent{Kind: IntentSpawn, Location: "europe", Rank: RankCavalry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gs.Orders(); len(got.Orders) != 2 || got.Username != "alice" {
+		t.Errorf("got batch %+v", got)
+	}
+	gs.ClearOrders()
+	if got := gs.Orders(); len(got.Orders) != 0 {
+		t.Errorf("got %v after clearing", got.Orders)
+	}
+}
@@ -0,0 +1,288 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// World is the server's authoritative view of the game. Clients only ever
+// send intents; every unit that exists was created here and every war is
+// fought here, once.
+type World struct {
+	mu       sync.Mutex
+	players  map[string]*GameState
+	paused   bool
+	seq      uint64
+	resolver CombatResolver
+	out      io.Writer
+
+	// In turn mode spawns and moves only arrive as orders, which are held
+	// until the turn is resolved.
+	turnMode bool
+	turn     int
+	orders   map[string]OrderBatch
+
+	ticks     int
+	treaties  map[[2]string]Treaty
+	proposals map[[2]string]DiplomacyMessage
+}
+
+func NewWorld() *World {
+	return &World{
+		players:   map[string]*GameState{},
+		resolver:  NewDiceResolver(time.Now().UnixNano()),
+		out:       os.Stdout,
+		treaties:  map[[2]string]Treaty{},
+		proposals: map[[2]string]DiplomacyMessage{},
+	}
+}
+
+// SetOutput sends what the world prints about the game to out instead of
+// stdout.
+func (w *World) SetOutput(out io.Writer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.out = out
+}
+
+// SetCombatResolver changes how wars are decided from now on.
+func (w *World) SetCombatResolver(resolver CombatResolver) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.resolver = resolver
+}
+
+func (w *World) SetPaused(paused bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = paused
+}
+
+func (w *World) HandleIntent(intent Intent) StateDelta {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.seq++
+	delta := StateDelta{
+		Seq:      w.seq,
+		Username: intent.Username,
+		Intent:   intent.Kind,
+		Turn:     w.turn,
+	}
+	if intent.Username == "" {
+		delta.Error = "intent has no username"
+		return delta
+	}
+	if w.turnMode && intent.Kind != IntentJoin {
+		delta.Error = "the game is turn based, queue your orders and submit them"
+		return delta
+	}
+
+	gs := w.player(intent.Username)
+	var err error
+	switch intent.Kind {
+	case IntentJoin:
+		fmt.Fprintf(w.out, "%s joined the game\n", intent.Username)
+		delta.Treasuries = map[string]int{intent.Username: gs.Treasury()}
+		delta.Treaties = w.treatiesOf(intent.Username)
+		for _, unit := range gs.getUnitsSnap() {
+			delta.Changes = append(delta.Changes, UnitChange{Username: intent.Username, Unit: unit})
+		}
+	case IntentSpawn:
+		err = w.spawn(gs, intent, &delta)
+	case IntentMove:
+		err = w.move(gs, intent, &delta)
+	default:
+		err = fmt.Errorf("unknown intent %q", intent.Kind)
+	}
+	if err != nil {
+		fmt.Fprintf(w.out, "Rejected %s from %s: %v\n", intent.Kind, intent.Username, err)
+		delta.Error = err.Error()
+		delta.Changes = nil
+		delta.Wars = nil
+		delta.Treasuries = nil
+	}
+	return delta
+}
+
+func (w *World) player(username string) *GameState {
+	gs, ok := w.players[username]
+	if !ok {
+		gs = NewGameState(username)
+		w.players[username] = gs
+	}
+	return gs
+}
+
+func (w *World) spawn(gs *GameState, intent Intent, delta *StateDelta) error {
+	err := gs.validateSpawn(intent.Location, intent.Rank)
+	if err != nil {
+		return err
+	}
+	if len(gs.getUnitsSnap()) == 0 {
+		// A player's first unit can go anywhere nobody else holds.
+		for name, other := range w.players {
+			if other != gs && other.holds(intent.Location) {
+				return fmt.Errorf("error: %s is held by %s", intent.Location, name)
+			}
+		}
+	}
+	gs.setTreasury(gs.Treasury() - rankCost[intent.Rank])
+	if delta.Treasuries == nil {
+		delta.Treasuries = map[string]int{}
+	}
+	delta.Treasuries[intent.Username] = gs.Treasury()
+
+	unit := Unit{
+		ID:       gs.nextUnitID(),
+		Rank:     intent.Rank,
+		Location: intent.Location,
+	}
+	gs.addUnit(unit)
+	delta.Changes = append(delta.Changes, UnitChange{Username: intent.Username, Unit: unit})
+	fmt.Fprintf(w.out, "%s spawned a(n) %s in %s with id %v\n", intent.Username, unit.Rank, unit.Location, unit.ID)
+	return nil
+}
+
+func (w *World) move(gs *GameState, intent Intent, delta *StateDelta) error {
+	err := w.relocate(gs, intent, delta)
+	if err != nil {
+		return err
+	}
+	w.fight(gs, intent.Location, delta)
+	return nil
+}
+
+func (w *World) relocate(gs *GameState, intent Intent, delta *StateDelta) error {
+	if w.paused {
+		return errors.New("the game is paused, you can not move units")
+	}
+	err := gs.validateMove(intent.Location, intent.UnitIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, unitID := range intent.UnitIDs {
+		unit, _ := gs.GetUnit(unitID)
+		unit.Location = intent.Location
+		gs.UpdateUnit(unit)
+		delta.Changes = append(delta.Changes, UnitChange{Username: intent.Username, Unit: unit})
+	}
+	fmt.Fprintf(w.out, "%s moved %v unit(s) to %s\n", intent.Username, len(intent.UnitIDs), intent.Location)
+	return nil
+}
+
+// fight has the attacker take on everyone else holding the location, one
+// player at a time, for as long as it has units left there. Players at peace
+// with the attacker share the location instead.
+func (w *World) fight(gs *GameState, location Location, delta *StateDelta) {
+	names := []string{}
+	for name := range w.players {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		defender := w.players[name]
+		if defender == gs || w.atPeace(gs.GetUsername(), name) {
+			continue
+		}
+		rw := RecognitionOfWar{
+			Attacker: gs.GetPlayerSnap(),
+			Defender: defender.GetPlayerSnap(),
+		}
+		if len(unitsAt(rw.Attacker, location)) == 0 {
+			break
+		}
+		if len(unitsAt(rw.Defender, location)) == 0 {
+			continue
+		}
+
+		report := fightWar(w.resolver, rw, location)
+		if report.Draw {
+			fmt.Fprintf(w.out, "A war between %s and %s in %s resulted in a draw\n", report.Attacker, report.Defender, report.Location)
+		} else {
+			fmt.Fprintf(w.out, "%s won a war against %s in %s\n", report.Winner, report.Loser, report.Location)
+		}
+		delta.Changes = append(delta.Changes, killUnits(gs, report.AttackerLosses)...)
+		delta.Changes = append(delta.Changes, killUnits(defender, report.DefenderLosses)...)
+		if !report.Draw {
+			loser := gs
+			if report.Loser == name {
+				loser = defender
+			}
+			delta.Changes = append(delta.Changes, w.retreat(loser, &report)...)
+		}
+		delta.Wars = append(delta.Wars, report)
+	}
+}
+
+// retreat moves the loser's surviving units out of the war's location, to
+// the first neighbor it holds, or else the first one no enemy holds. With
+// nowhere to go they surrender, and count as lost.
+func (w *World) retreat(loser *GameState, report *CombatReport) []UnitChange {
+	username := loser.GetUsername()
+	survivors := report.Survivors(username)
+	if len(survivors) == 0 {
+		return nil
+	}
+
+	to := Location("")
+	free := Location("")
+	for _, loc := range CurrentMap().Neighbors(report.Location) {
+		if loser.holds(loc) {
+			to = loc
+			break
+		}
+		if free == "" && !w.enemyHolds(username, loc) {
+			free = loc
+		}
+	}
+	if to == "" {
+		to = free
+	}
+	if to == "" {
+		fmt.Fprintf(w.out, "%s had nowhere to retreat to from %s and surrendered\n", username, report.Location)
+		if username == report.Attacker {
+			report.AttackerLosses = append(report.AttackerLosses, survivors...)
+		} else {
+			report.DefenderLosses = append(report.DefenderLosses, survivors...)
+		}
+		return killUnits(loser, survivors)
+	}
+
+	report.RetreatTo = to
+	changes := []UnitChange{}
+	for _, unit := range survivors {
+		unit.Location = to
+		loser.UpdateUnit(unit)
+		report.Retreated = append(report.Retreated, unit)
+		changes = append(changes, UnitChange{Username: username, Unit: unit})
+	}
+	fmt.Fprintf(w.out, "%s retreated from %s to %s with %v unit(s)\n", username, report.Location, to, len(survivors))
+	return changes
+}
+
+// enemyHolds reports whether anyone not at peace with username has units at
+// loc.
+func (w *World) enemyHolds(username string, loc Location) bool {
+	for name, gs := range w.players {
+		if name != username && !w.atPeace(username, name) && gs.holds(loc) {
+			return true
+		}
+	}
+	return false
+}
+
+func killUnits(gs *GameState, units []Unit) []UnitChange {
+	changes := []UnitChange{}
+	for _, unit := range units {
+		gs.removeUnit(unit.ID)
+		changes = append(changes, UnitChange{Username: gs.GetUsername(), Unit: unit, Removed: true})
+	}
+	return changes
+}
@@ -0,0 +1,644 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+var ErrNotConnected = errors.New("not connected to RabbitMQ")
+
+type ConnectionState int
+
+const (
+	StateConnected ConnectionState = iota
+	StateDisconnected
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int(s))
+}
+
+// AMQPBroker is a managed connection to RabbitMQ. When the connection drops
+// it keeps reconnecting with backoff, re-declares every exchange, queue and
+// binding it has created and restarts every consumer with its original
+// handler.
+type AMQPBroker struct {
+	url  string
+	done chan struct{}
+
+	mu        sync.Mutex
+	conn      *amqp.Connection
+	pubCh     *amqp.Channel
+	connected bool
+	closed    bool
+	exchanges []exchangeDecl
+	queues    []queueDecl
+	bindings  []queueBinding
+	binds     []bindDecl
+	consumers []*amqpConsumer
+	listeners []chan ConnectionState
+}
+
+type exchangeDecl struct {
+	name string
+	kind ExchangeKind
+}
+
+type queueDecl struct {
+	name string
+	opts QueueOptions
+}
+
+type queueBinding struct {
+	exchange        string
+	queueName       string
+	bindingKey      string
+	simpleQueueType QueueType
+}
+
+type bindDecl struct {
+	queueName  string
+	exchange   string
+	bindingKey string
+}
+
+type amqpConsumer struct {
+	broker    *AMQPBroker
+	queueName string
+	prefetch  int
+	handler   func(Delivery) AckType
+	sub       *Subscription
+
+	mu      sync.Mutex
+	ch      *amqp.Channel
+	tag     string
+	running bool
+}
+
+var consumerSeq atomic.Uint64
+
+// AMQPURL is the URL DialAMQP takes to log in to RabbitMQ at addr, like
+// localhost:5672, as login.
+func AMQPURL(addr, login, password string) string {
+	u := url.URL{
+		Scheme: "amqp",
+		User:   url.UserPassword(login, password),
+		Host:   addr,
+		Path:   "/",
+	}
+	return u.String()
+}
+
+func DialAMQP(url string) (*AMQPBroker, error) {
+	b := &AMQPBroker{
+		url:  url,
+		done: make(chan struct{}),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.connect()
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// NotifyState registers a listener for connection state changes and returns
+// it. Events are dropped rather than blocking the reconnect loop, so give the
+// channel some buffer.
+func (b *AMQPBroker) NotifyState(c chan ConnectionState) chan ConnectionState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, c)
+	return c
+}
+
+func (b *AMQPBroker) Publish(ctx context.Context, exchange, routingKey string, msg Publishing) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.connected {
+		return ErrNotConnected
+	}
+	err := b.pubCh.PublishWithContext(
+		ctx,
+		exchange,   // exchange
+		routingKey, // routing key
+		false,      // mandatory
+		false,      // immediate
+		amqpPublishing(msg))
+	if err != nil {
+		return fmt.Errorf("error publishing message: %v", err)
+	}
+	return nil
+}
+
+// amqpPublishing maps msg to AMQP properties and headers.
+func amqpPublishing(msg Publishing) amqp.Publishing {
+	headers := maps.Clone(msg.Headers)
+	return amqp.Publishing{
+		ContentType:   msg.ContentType,
+		Headers:       amqp.Table(msg.Metadata.setHeaders(headers)),
+		Body:          msg.Body,
+		MessageId:     msg.Metadata.MessageID,
+		Timestamp:     msg.Metadata.Timestamp,
+		AppId:         msg.Metadata.AppID,
+		UserId:        msg.Metadata.UserID,
+		CorrelationId: msg.Metadata.CorrelationID,
+	}
+}
+
+func (b *AMQPBroker) DeclareExchange(name string, kind ExchangeKind) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.connected {
+		return ErrNotConnected
+	}
+	err := declareExchange(b.conn, name, kind)
+	if err != nil {
+		return err
+	}
+	decl := exchangeDecl{name: name, kind: kind}
+	for _, e := range b.exchanges {
+		if e == decl {
+			return nil
+		}
+	}
+	b.exchanges = append(b.exchanges, decl)
+	return nil
+}
+
+func (b *AMQPBroker) DeclareQueue(name string, opts QueueOptions) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.connected {
+		return ErrNotConnected
+	}
+	decl := queueDecl{name: name, opts: opts}
+	err := declareQueue(b.conn, decl)
+	if err != nil {
+		return err
+	}
+	for _, q := range b.queues {
+		if q == decl {
+			return nil
+		}
+	}
+	b.queues = append(b.queues, decl)
+	return nil
+}
+
+func (b *AMQPBroker) DeclareAndBind(exchange, queueName, bindingKey string, simpleQueueType QueueType) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.connected {
+		return ErrNotConnected
+	}
+	binding := queueBinding{
+		exchange:        exchange,
+		queueName:       queueName,
+		bindingKey:      bindingKey,
+		simpleQueueType: simpleQueueType,
+	}
+	err := declareAndBind(b.conn, binding)
+	if err != nil {
+		return err
+	}
+	for _, qb := range b.bindings {
+		if qb == binding {
+			return nil
+		}
+	}
+	b.bindings = append(b.bindings, binding)
+	return nil
+}
+
+func (b *AMQPBroker) BindQueue(queueName, exchange, bindingKey string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.connected {
+		return ErrNotConnected
+	}
+	decl := bindDecl{queueName: queueName, exchange: exchange, bindingKey: bindingKey}
+	err := bindQueue(b.conn, decl)
+	if err != nil {
+		return err
+	}
+	for _, bd := range b.binds {
+		if bd == decl {
+			return nil
+		}
+	}
+	b.binds = append(b.binds, decl)
+	return nil
+}
+
+func (b *AMQPBroker) Consume(queueName string, prefetch int, handler func(Delivery) AckType) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.connected {
+		return nil, ErrNotConnected
+	}
+	c := &amqpConsumer{
+		broker:    b,
+		queueName: queueName,
+		prefetch:  prefetch,
+		handler:   handler,
+	}
+	c.sub = newSubscription(c.stop)
+	err := c.start(b.conn)
+	if err != nil {
+		return nil, err
+	}
+	b.consumers = append(b.consumers, c)
+	return c.sub, nil
+}
+
+func (b *AMQPBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	if !b.connected {
+		return nil
+	}
+	b.connected = false
+	b.pubCh.Close()
+	return b.conn.Close()
+}
+
+// connect dials RabbitMQ and restores all registered topology and consumers.
+// b.mu must be held.
+func (b *AMQPBroker) connect() error {
+	conn, err := amqp.Dial(b.url)
+	if err != nil {
+		return fmt.Errorf("error connecting to RabbitMQ: %v", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error creating channel: %v", err)
+	}
+
+	for _, e := range b.exchanges {
+		err = declareExchange(conn, e.name, e.kind)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	for _, q := range b.queues {
+		err = declareQueue(conn, q)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	for _, qb := range b.bindings {
+		err = declareAndBind(conn, qb)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	for _, bd := range b.binds {
+		err = bindQueue(conn, bd)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	// b.consumers stays as it was until they have all started, so a failed
+	// attempt leaves the next one the same list to work through.
+	consumers := make([]*amqpConsumer, 0, len(b.consumers))
+	for _, c := range b.consumers {
+		select {
+		case <-c.sub.Done():
+			// stopped for good, don't bring it back
+			continue
+		default:
+		}
+		err = c.start(conn)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		consumers = append(consumers, c)
+	}
+	b.consumers = consumers
+
+	b.conn = conn
+	b.pubCh = ch
+	b.connected = true
+	go b.watch(conn.NotifyClose(make(chan *amqp.Error, 1)))
+	return nil
+}
+
+// watch waits for the connection to drop and reconnects until it succeeds or
+// the broker is closed.
+func (b *AMQPBroker) watch(closed chan *amqp.Error) {
+	amqpErr, ok := <-closed
+	if !ok || amqpErr == nil {
+		// closed on purpose
+		return
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.connected = false
+	b.mu.Unlock()
+	b.notify(StateDisconnected)
+
+	delay := minReconnectDelay
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-time.After(delay):
+		}
+
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return
+		}
+		err := b.connect()
+		b.mu.Unlock()
+		if err == nil {
+			b.notify(StateConnected)
+			return
+		}
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+func (b *AMQPBroker) notify(state ConnectionState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, l := range b.listeners {
+		select {
+		case l <- state:
+		default:
+		}
+	}
+}
+
+func declareExchange(conn *amqp.Connection, name string, kind ExchangeKind) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("error creating channel: %v", err)
+	}
+	defer ch.Close()
+
+	err = ch.ExchangeDeclare(
+		name,         // name
+		string(kind), // kind
+		true,         // durable
+		false,        // auto-delete
+		false,        // internal
+		false,        // no-wait
+		nil,          // args
+	)
+	if err != nil {
+		return fmt.Errorf("error declaring exchange: %v", err)
+	}
+	return nil
+}
+
+func declareQueue(conn *amqp.Connection, q queueDecl) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("error creating channel: %v", err)
+	}
+	defer ch.Close()
+
+	args := amqp.Table{}
+	if q.opts.MessageTTL > 0 {
+		args["x-message-ttl"] = q.opts.MessageTTL.Milliseconds()
+	}
+	if q.opts.DeadLetterExchange != "" || q.opts.DeadLetterRoutingKey != "" {
+		args["x-dead-letter-exchange"] = q.opts.DeadLetterExchange
+	}
+	if q.opts.DeadLetterRoutingKey != "" {
+		args["x-dead-letter-routing-key"] = q.opts.DeadLetterRoutingKey
+	}
+
+	_, err = ch.QueueDeclare(
+		q.name,           // name
+		q.opts.Durable,   // durable
+		false,            // delete when unused
+		q.opts.Exclusive, // exclusive
+		false,            // no-wait
+		args,
+	)
+	if err != nil {
+		return fmt.Errorf("error declaring queue: %v", err)
+	}
+	return nil
+}
+
+func bindQueue(conn *amqp.Connection, bd bindDecl) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("error creating channel: %v", err)
+	}
+	defer ch.Close()
+
+	err = ch.QueueBind(
+		bd.queueName,  // queue name
+		bd.bindingKey, // routing key
+		bd.exchange,   // exchange
+		false,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("error binding queue: %v", err)
+	}
+	return nil
+}
+
+func declareAndBind(conn *amqp.Connection, qb queueBinding) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("error creating channel: %v", err)
+	}
+	defer ch.Close()
+
+	q, err := ch.QueueDeclare(
+		qb.queueName,                         // name
+		qb.simpleQueueType == DurableQueue,   // durable
+		qb.simpleQueueType == TransientQueue, // delete when unused
+		qb.simpleQueueType == TransientQueue, // exclusive
+		false,                                // no-wait
+		amqp.Table{"x-dead-letter-exchange": DeadLetterExchange},
+	)
+	if err != nil {
+		return fmt.Errorf("error declaring queue: %v", err)
+	}
+
+	err = ch.QueueBind(
+		q.Name,        // queue name
+		qb.bindingKey, // routing key
+		qb.exchange,   // exchange
+		false,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("error binding queue: %v", err)
+	}
+	return nil
+}
+
+// start opens a channel on conn and consumes from the queue until the channel
+// closes.
+func (c *amqpConsumer) start(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("error creating channel: %v", err)
+	}
+
+	if c.prefetch > 0 {
+		err = ch.Qos(c.prefetch, 0, false)
+		if err != nil {
+			ch.Close()
+			return fmt.Errorf("error setting qos: %v", err)
+		}
+	}
+
+	tag := fmt.Sprintf("%s-%d", c.queueName, consumerSeq.Add(1))
+	msgs, err := ch.Consume(
+		c.queueName, // queue
+		tag,         // consumer
+		false,       // auto-ack
+		false,       // exclusive
+		false,       // no-local
+		false,       // no-wait
+		nil,         // args
+	)
+	if err != nil {
+		ch.Close()
+		return fmt.Errorf("error consuming: %v", err)
+	}
+	chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	c.mu.Lock()
+	c.ch = ch
+	c.tag = tag
+	c.running = true
+	c.mu.Unlock()
+
+	go c.run(conn, ch, msgs, chClosed)
+	return nil
+}
+
+func (c *amqpConsumer) run(conn *amqp.Connection, ch *amqp.Channel, msgs <-chan amqp.Delivery, chClosed chan *amqp.Error) {
+	for d := range msgs {
+		ackType := settleWithoutRetry(c.handler(amqpDelivery(d)))
+		switch ackType {
+		case Ack:
+			d.Ack(false)
+		case NackRequeue:
+			d.Nack(false, true)
+		case NackDiscard:
+			d.Nack(false, false)
+		}
+	}
+
+	c.mu.Lock()
+	if c.ch == ch {
+		c.running = false
+	}
+	c.mu.Unlock()
+
+	if c.sub.isClosing() {
+		ch.Close()
+		c.sub.finish(nil)
+		return
+	}
+
+	c.broker.mu.Lock()
+	brokerClosed := c.broker.closed
+	c.broker.mu.Unlock()
+	if brokerClosed {
+		c.sub.finish(ErrClosed)
+		return
+	}
+	if conn.IsClosed() {
+		// the broker restarts us once it has reconnected
+		return
+	}
+
+	// The channel went away while the connection stayed up, e.g. because
+	// the queue was deleted.
+	var reason error = errors.New("consumer cancelled by server")
+	select {
+	case amqpErr := <-chClosed:
+		if amqpErr != nil {
+			reason = amqpErr
+		}
+	default:
+	}
+	ch.Close()
+	c.sub.finish(fmt.Errorf("consumer on %s stopped: %v", c.queueName, reason))
+}
+
+// stop cancels the consumer. Deliveries already received are still handled
+// before run finishes the subscription.
+func (c *amqpConsumer) stop() error {
+	c.mu.Lock()
+	running, ch, tag := c.running, c.ch, c.tag
+	c.mu.Unlock()
+	if !running {
+		c.sub.finish(nil)
+		return nil
+	}
+	return ch.Cancel(tag, false)
+}
+
+func amqpDelivery(d amqp.Delivery) Delivery {
+	delivery := Delivery{
+		Metadata: Metadata{
+			MessageID:     d.MessageId,
+			Timestamp:     d.Timestamp,
+			AppID:         d.AppId,
+			UserID:        d.UserId,
+			CorrelationID: d.CorrelationId,
+		},
+		Exchange:    d.Exchange,
+		RoutingKey:  d.RoutingKey,
+		ContentType: d.ContentType,
+		Headers:     map[string]any(d.Headers),
+		Body:        d.Body,
+		Redelivered: d.Redelivered,
+	}
+	delivery.Metadata.takeHeaders(delivery.Headers)
+	return delivery
+}
@@ -0,0 +1,303 @@
+package pubsub_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+// fakeAMQP speaks just enough AMQP 0-9-1 for AMQPBroker to connect, declare
+// queues and consume from them. Every method a client sends is reported on
+// events, e.g. "queue.declare moves".
+type fakeAMQP struct {
+	ln     net.Listener
+	events chan string
+
+	mu    sync.Mutex
+	conns []*fakeAMQPConn
+}
+
+type fakeAMQPConn struct {
+	conn net.Conn
+
+	mu        sync.Mutex
+	consumers map[string]fakeConsumer
+	nextTag   uint64
+}
+
+type fakeConsumer struct {
+	channel uint16
+	tag     string
+}
+
+func startFakeAMQP(t *testing.T) *fakeAMQP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeAMQP{ln: ln, events: make(chan string, 100)}
+	t.Cleanup(func() { s.ln.Close(); s.drop() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c := &fakeAMQPConn{conn: conn, consumers: map[string]fakeConsumer{}}
+			s.mu.Lock()
+			s.conns = append(s.conns, c)
+			s.mu.Unlock()
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeAMQP) url() string {
+	return pubsub.AMQPURL(s.ln.Addr().String(), "guest", "guest")
+}
+
+// drop cuts every connection the way a restarting broker would.
+func (s *fakeAMQP) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.conn.Close()
+	}
+	s.conns = nil
+}
+
+// deliver hands body to the consumer of queueName on the newest connection.
+func (s *fakeAMQP) deliver(t *testing.T, queueName string, body []byte) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.conns) - 1; i >= 0; i-- {
+		c := s.conns[i]
+		c.mu.Lock()
+		consumer, ok := c.consumers[queueName]
+		c.nextTag++
+		tag := c.nextTag
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		args := &bytes.Buffer{}
+		writeShortstr(args, consumer.tag)
+		binary.Write(args, binary.BigEndian, tag)
+		args.WriteByte(0) // redelivered
+		writeShortstr(args, "")
+		writeShortstr(args, queueName)
+		header := &bytes.Buffer{}
+		binary.Write(header, binary.BigEndian, []uint16{60, 0})
+		binary.Write(header, binary.BigEndian, uint64(len(body)))
+		binary.Write(header, binary.BigEndian, uint16(0)) // no properties
+
+		c.writeMethod(consumer.channel, 60, 60, args.Bytes())
+		c.writeFrame(2, consumer.channel, header.Bytes())
+		c.writeFrame(3, consumer.channel, body)
+		return
+	}
+	t.Fatalf("nobody consumes from %s", queueName)
+}
+
+// expect skips events until want comes along.
+func (s *fakeAMQP) expect(t *testing.T, want string) {
+	t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case got := <-s.events:
+			if got == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+}
+
+func (s *fakeAMQP) serve(c *fakeAMQPConn) {
+	defer c.conn.Close()
+	r := bufio.NewReader(c.conn)
+	header := make([]byte, 8)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return
+	}
+
+	start := &bytes.Buffer{}
+	start.Write([]byte{0, 9})
+	binary.Write(start, binary.BigEndian, uint32(0)) // server properties
+	writeLongstr(start, "PLAIN")
+	writeLongstr(start, "en_US")
+	c.writeMethod(0, 10, 10, start.Bytes())
+
+	for {
+		frameType, channel, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		if frameType != 1 {
+			// heartbeats, and content the test never publishes
+			continue
+		}
+		class := binary.BigEndian.Uint16(payload[0:2])
+		method := binary.BigEndian.Uint16(payload[2:4])
+		args := bytes.NewReader(payload[4:])
+
+		switch [2]uint16{class, method} {
+		case [2]uint16{10, 11}: // connection.start-ok
+			tune := &bytes.Buffer{}
+			binary.Write(tune, binary.BigEndian, uint16(2047))
+			binary.Write(tune, binary.BigEndian, uint32(131072))
+			binary.Write(tune, binary.BigEndian, uint16(0))
+			c.writeMethod(0, 10, 30, tune.Bytes())
+		case [2]uint16{10, 40}: // connection.open
+			c.writeMethod(0, 10, 41, []byte{0})
+		case [2]uint16{10, 50}: // connection.close
+			c.writeMethod(0, 10, 51, nil)
+			return
+		case [2]uint16{20, 10}: // channel.open
+			c.writeMethod(channel, 20, 11, []byte{0, 0, 0, 0})
+		case [2]uint16{20, 40}: // channel.close
+			c.writeMethod(channel, 20, 41, nil)
+		case [2]uint16{40, 10}: // exchange.declare
+			c.writeMethod(channel, 40, 11, nil)
+		case [2]uint16{50, 10}: // queue.declare
+			args.Seek(2, io.SeekCurrent)
+			name := readShortstr(args)
+			s.events <- "queue.declare " + name
+			ok := &bytes.Buffer{}
+			writeShortstr(ok, name)
+			binary.Write(ok, binary.BigEndian, []uint32{0, 0})
+			c.writeMethod(channel, 50, 11, ok.Bytes())
+		case [2]uint16{50, 20}: // queue.bind
+			c.writeMethod(channel, 50, 21, nil)
+		case [2]uint16{60, 10}: // basic.qos
+			c.writeMethod(channel, 60, 11, nil)
+		case [2]uint16{60, 20}: // basic.consume
+			args.Seek(2, io.SeekCurrent)
+			queueName := readShortstr(args)
+			tag := readShortstr(args)
+			c.mu.Lock()
+			c.consumers[queueName] = fakeConsumer{channel: channel, tag: tag}
+			c.mu.Unlock()
+			ok := &bytes.Buffer{}
+			writeShortstr(ok, tag)
+			c.writeMethod(channel, 60, 21, ok.Bytes())
+			s.events <- "basic.consume " + queueName
+		case [2]uint16{60, 30}: // basic.cancel
+			tag := readShortstr(args)
+			ok := &bytes.Buffer{}
+			writeShortstr(ok, tag)
+			c.writeMethod(channel, 60, 31, ok.Bytes())
+		case [2]uint16{60, 80}: // basic.ack
+			s.events <- "basic.ack"
+		}
+	}
+}
+
+func (c *fakeAMQPConn) writeMethod(channel, class, method uint16, args []byte) {
+	payload := binary.BigEndian.AppendUint16(nil, class)
+	payload = binary.BigEndian.AppendUint16(payload, method)
+	c.writeFrame(1, channel, append(payload, args...))
+}
+
+func (c *fakeAMQPConn) writeFrame(frameType byte, channel uint16, payload []byte) {
+	frame := []byte{frameType}
+	frame = binary.BigEndian.AppendUint16(frame, channel)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(payload)))
+	frame = append(frame, payload...)
+	frame = append(frame, 0xCE)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.Write(frame)
+}
+
+func readFrame(r *bufio.Reader) (frameType byte, channel uint16, payload []byte, err error) {
+	header := make([]byte, 7)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	payload = make([]byte, binary.BigEndian.Uint32(header[3:7])+1)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if payload[len(payload)-1] != 0xCE {
+		return 0, 0, nil, fmt.Errorf("bad frame end")
+	}
+	return header[0], binary.BigEndian.Uint16(header[1:3]), payload[:len(payload)-1], nil
+}
+
+func readShortstr(r *bytes.Reader) string {
+	n, _ := r.ReadByte()
+	s := make([]byte, n)
+	io.ReadFull(r, s)
+	return string(s)
+}
+
+func writeShortstr(w *bytes.Buffer, s string) {
+	w.WriteByte(byte(len(s)))
+	w.WriteString(s)
+}
+
+func writeLongstr(w *bytes.Buffer, s string) {
+	binary.Write(w, binary.BigEndian, uint32(len(s)))
+	w.WriteString(s)
+}
+
+func TestAMQPReconnect(t *testing.T) {
+	srv := startFakeAMQP(t)
+	broker, err := pubsub.DialAMQP(srv.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { broker.Close() })
+	states := broker.NotifyState(make(chan pubsub.ConnectionState, 4))
+
+	err = broker.DeclareQueue("moves", pubsub.QueueOptions{Durable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries := make(chan pubsub.Delivery, 1)
+	sub, err := broker.Consume("moves", 0, func(d pubsub.Delivery) pubsub.AckType {
+		deliveries <- d
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+	srv.expect(t, "basic.consume moves")
+
+	srv.drop()
+	if got := receive(t, states); got != pubsub.StateDisconnected {
+		t.Fatalf("got state %v, want disconnected", got)
+	}
+	if got := receive(t, states); got != pubsub.StateConnected {
+		t.Fatalf("got state %v, want connected", got)
+	}
+
+	// The queue is declared again and the consumer restarted with its
+	// original handler.
+	srv.expect(t, "queue.declare moves")
+	srv.expect(t, "basic.consume moves")
+	srv.deliver(t, "moves", []byte("europe"))
+	if d := receive(t, deliveries); string(d.Body) != "europe" {
+		t.Errorf("got body %q", d.Body)
+	}
+	srv.expect(t, "basic.ack")
+}
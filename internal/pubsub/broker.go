@@ -0,0 +1,76 @@
+package pubsub
+
+import (
+	"context"
+	"time"
+)
+
+type ExchangeKind string
+
+const (
+	ExchangeKindDirect ExchangeKind = "direct"
+	ExchangeKindTopic  ExchangeKind = "topic"
+	ExchangeKindFanout ExchangeKind = "fanout"
+)
+
+// DeadLetterExchange is the exchange every queue declared through
+// DeclareAndBind dead-letters discarded messages to.
+const DeadLetterExchange = "peril_dlx"
+
+// Publishing is a message on its way to an exchange.
+type Publishing struct {
+	Metadata    Metadata
+	ContentType string
+	Headers     map[string]any
+	Body        []byte
+}
+
+// Delivery is a message handed to a consumer.
+type Delivery struct {
+	Metadata    Metadata
+	Exchange    string
+	RoutingKey  string
+	ContentType string
+	Headers     map[string]any
+	Body        []byte
+	Redelivered bool
+}
+
+// QueueOptions describes a queue that is not bound to any exchange, such as
+// the delay and parking queues used by retry policies.
+type QueueOptions struct {
+	Durable bool
+	// Exclusive queues belong to the connection that declared them and are
+	// deleted with it, like transient ones.
+	Exclusive bool
+	// MessageTTL expires messages after they have waited this long; 0 keeps
+	// them forever.
+	MessageTTL time.Duration
+	// Expired and discarded messages are republished to DeadLetterExchange
+	// with DeadLetterRoutingKey, or their own routing key if that is empty.
+	DeadLetterExchange   string
+	DeadLetterRoutingKey string
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, exchange, routingKey string, msg Publishing) error
+}
+
+type Subscriber interface {
+	DeclareAndBind(exchange, queueName, bindingKey string, simpleQueueType QueueType) error
+	DeclareQueue(name string, opts QueueOptions) error
+	// Consume starts delivering messages from queueName to handler in the
+	// background. prefetch limits unacknowledged deliveries; 0 means no limit.
+	Consume(queueName string, prefetch int, handler func(Delivery) AckType) (*Subscription, error)
+}
+
+// Broker is everything the game needs from a message broker. AMQPBroker talks
+// to RabbitMQ, MemoryConn runs entirely in-process.
+type Broker interface {
+	Publisher
+	Subscriber
+	DeclareExchange(name string, kind ExchangeKind) error
+	// BindQueue binds a queue declared with DeclareQueue to an exchange.
+	BindQueue(queueName, exchange, bindingKey string) error
+	Close() error
+}
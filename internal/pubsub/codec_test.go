@@ -0,0 +1,44 @@
+package pubsub_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// TestCodecsGameLog publishes a game log in every encoding to a single
+// subscription, which has to accept them all.
+func TestCodecsGameLog(t *testing.T) {
+	_, conn := startMemory(t)
+	got := make(chan pubsub.Message[routing.GameLog], 1)
+	sub, err := pubsub.Subscribe(conn, routing.ExchangePerilTopic, routing.GameLogSlug, routing.GameLogSlug+".*", pubsub.DurableQueue, func(_ context.Context, d pubsub.Message[routing.GameLog]) pubsub.AckType {
+		got <- d
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+
+	want := routing.GameLog{
+		CurrentTime: time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC),
+		Message:     "alice won a war against bob in europe",
+		Username:    "alice",
+	}
+	for _, codec := range []pubsub.Codec{pubsub.JSON, pubsub.Gob, pubsub.Protobuf, pubsub.MsgPack} {
+		err := pubsub.Publish(context.Background(), conn, codec, routing.ExchangePerilTopic, routing.GameLogSlug+".alice", want)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.ContentType(), err)
+		}
+		d := receive(t, got)
+		if d.ContentType != codec.ContentType() {
+			t.Errorf("got %s, want %s", d.ContentType, codec.ContentType())
+		}
+		if !d.Msg.CurrentTime.Equal(want.CurrentTime) || d.Msg.Message != want.Message || d.Msg.Username != want.Username {
+			t.Errorf("%s: got %+v, want %+v", codec.ContentType(), d.Msg, want)
+		}
+	}
+}
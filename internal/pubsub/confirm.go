@@ -0,0 +1,256 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrNacked is returned when the broker refuses responsibility for a message.
+var ErrNacked = errors.New("message nacked by broker")
+
+// UnroutableError is returned when a mandatory message matched no queue and
+// was sent back by the broker.
+type UnroutableError struct {
+	Exchange   string
+	RoutingKey string
+	ReplyCode  uint16
+	ReplyText  string
+}
+
+func (e *UnroutableError) Error() string {
+	return fmt.Sprintf("message to %s with key %s was returned: %d %s", e.Exchange, e.RoutingKey, e.ReplyCode, e.ReplyText)
+}
+
+// ConfirmedPublisher publishes on a channel in confirm mode with the mandatory
+// flag set, so Publish only succeeds once RabbitMQ has routed the message to
+// at least one queue and taken responsibility for it.
+type ConfirmedPublisher struct {
+	broker *AMQPBroker
+
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	ch      *amqp.Channel
+	pending *pendingConfirms
+}
+
+// pendingConfirms tracks the messages published on one channel until
+// RabbitMQ confirms them, by delivery tag.
+type pendingConfirms struct {
+	mu      sync.Mutex
+	waiting map[uint64]*pendingMessage
+	closed  bool
+}
+
+// pendingMessage is what a basic.return, which has no delivery tag, is
+// matched against.
+type pendingMessage struct {
+	result     chan error
+	exchange   string
+	routingKey string
+	body       []byte
+	returned   *amqp.Return
+}
+
+// BatchItem is a single message in a PublishBatch call.
+type BatchItem struct {
+	Exchange   string
+	RoutingKey string
+	Msg        Publishing
+}
+
+func (b *AMQPBroker) ConfirmedPublisher() *ConfirmedPublisher {
+	return &ConfirmedPublisher{broker: b}
+}
+
+// Publish sends msg and waits for the broker to confirm it.
+func (p *ConfirmedPublisher) Publish(ctx context.Context, exchange, routingKey string, msg Publishing) error {
+	return p.PublishBatch(ctx, []BatchItem{{
+		Exchange:   exchange,
+		RoutingKey: routingKey,
+		Msg:        msg,
+	}})
+}
+
+// PublishBatch sends every item before waiting for any confirmation, which is
+// much faster than confirming one message at a time. The returned error joins
+// the failures of all items that were not confirmed.
+func (p *ConfirmedPublisher) PublishBatch(ctx context.Context, items []BatchItem) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ch, pending, err := p.channel()
+	if err != nil {
+		return err
+	}
+
+	results := make([]chan error, 0, len(items))
+	for _, item := range items {
+		tag := ch.GetNextPublishSeqNo()
+		result, err := pending.add(tag, item)
+		if err != nil {
+			return err
+		}
+		err = ch.PublishWithContext(
+			ctx,
+			item.Exchange,   // exchange
+			item.RoutingKey, // routing key
+			true,            // mandatory
+			false,           // immediate
+			amqpPublishing(item.Msg))
+		if err != nil {
+			pending.remove(tag)
+			return fmt.Errorf("error publishing message: %v", err)
+		}
+		results = append(results, result)
+	}
+
+	var errs []error
+	for _, result := range results {
+		select {
+		case err := <-result:
+			if err != nil {
+				errs = append(errs, err)
+			}
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("error waiting for confirmation: %v", ctx.Err()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// channel returns the confirm-mode channel, opening a new one when the
+// broker has reconnected since it was last used. p.mu must be held.
+func (p *ConfirmedPublisher) channel() (*amqp.Channel, *pendingConfirms, error) {
+	p.broker.mu.Lock()
+	conn, connected := p.broker.conn, p.broker.connected
+	p.broker.mu.Unlock()
+	if !connected {
+		return nil, nil, ErrNotConnected
+	}
+	if p.ch != nil && p.conn == conn && !p.ch.IsClosed() {
+		return p.ch, p.pending, nil
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating channel: %v", err)
+	}
+	err = ch.Confirm(false)
+	if err != nil {
+		ch.Close()
+		return nil, nil, fmt.Errorf("error enabling confirm mode: %v", err)
+	}
+	pending := &pendingConfirms{waiting: map[uint64]*pendingMessage{}}
+	go pending.watch(ch.NotifyReturn(make(chan amqp.Return)), ch.NotifyPublish(make(chan amqp.Confirmation)))
+	p.conn = conn
+	p.ch = ch
+	p.pending = pending
+	return ch, pending, nil
+}
+
+// watch settles pending messages as their confirmations arrive, for as long
+// as the channel is open. Both channels are unbuffered and read by this one
+// goroutine: RabbitMQ sends a message's basic.return before its ack, so the
+// return has always been matched by the time the ack is.
+func (pc *pendingConfirms) watch(returns <-chan amqp.Return, confirms <-chan amqp.Confirmation) {
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			pc.markReturned(ret)
+		case c, ok := <-confirms:
+			if !ok {
+				pc.close()
+				return
+			}
+			pc.settle(c)
+		}
+	}
+}
+
+func (pc *pendingConfirms) add(tag uint64, item BatchItem) (chan error, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.closed {
+		return nil, fmt.Errorf("error publishing message: %v", amqp.ErrClosed)
+	}
+	result := make(chan error, 1)
+	pc.waiting[tag] = &pendingMessage{
+		result:     result,
+		exchange:   item.Exchange,
+		routingKey: item.RoutingKey,
+		body:       item.Msg.Body,
+	}
+	return result, nil
+}
+
+// markReturned finds the message ret sends back: the oldest one waiting
+// that went to the same place with the same body. Returns come in the order
+// the messages were published, so it is the right one, or one that nobody
+// could tell apart from it.
+func (pc *pendingConfirms) markReturned(ret amqp.Return) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	var oldest *pendingMessage
+	var oldestTag uint64
+	for tag, m := range pc.waiting {
+		if m.returned != nil || m.exchange != ret.Exchange || m.routingKey != ret.RoutingKey || !bytes.Equal(m.body, ret.Body) {
+			continue
+		}
+		if oldest == nil || tag < oldestTag {
+			oldest, oldestTag = m, tag
+		}
+	}
+	if oldest != nil {
+		oldest.returned = &ret
+	}
+}
+
+func (pc *pendingConfirms) remove(tag uint64) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	delete(pc.waiting, tag)
+}
+
+func (pc *pendingConfirms) settle(c amqp.Confirmation) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	m, ok := pc.waiting[c.DeliveryTag]
+	if !ok {
+		return
+	}
+	delete(pc.waiting, c.DeliveryTag)
+	switch {
+	case m.returned != nil:
+		m.result <- &UnroutableError{
+			Exchange:   m.returned.Exchange,
+			RoutingKey: m.returned.RoutingKey,
+			ReplyCode:  m.returned.ReplyCode,
+			ReplyText:  m.returned.ReplyText,
+		}
+	case !c.Ack:
+		m.result <- ErrNacked
+	default:
+		m.result <- nil
+	}
+}
+
+// close fails every message still waiting, once the channel has closed
+// without confirming them.
+func (pc *pendingConfirms) close() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.closed = true
+	for tag, m := range pc.waiting {
+		delete(pc.waiting, tag)
+		m.result <- fmt.Errorf("error waiting for confirmation: %v", amqp.ErrClosed)
+	}
+}
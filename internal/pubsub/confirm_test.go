@@ -0,0 +1,51 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestPendingConfirmsMatchReturns(t *testing.T) {
+	pc := &pendingConfirms{waiting: map[uint64]*pendingMessage{}}
+	item := func(key, body string) BatchItem {
+		return BatchItem{Exchange: "peril_topic", RoutingKey: key, Msg: Publishing{Body: []byte(body)}}
+	}
+	results := map[uint64]chan error{}
+	for tag, it := range map[uint64]BatchItem{
+		1: item("war.alice", "a"),
+		2: item("war.alice", "b"),
+		3: item("war.bob", "b"),
+		4: item("war.alice", "b"),
+	} {
+		result, err := pc.add(tag, it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results[tag] = result
+	}
+
+	// Message 2 is returned and acked, 4 is identical but routed. The
+	// other messages' acks can come later.
+	pc.markReturned(amqp.Return{Exchange: "peril_topic", RoutingKey: "war.alice", Body: []byte("b"), ReplyCode: 312})
+	pc.settle(amqp.Confirmation{DeliveryTag: 2, Ack: true})
+	for tag := uint64(1); tag <= 4; tag++ {
+		if tag != 2 {
+			pc.settle(amqp.Confirmation{DeliveryTag: tag, Ack: tag != 3})
+		}
+	}
+
+	var unroutable *UnroutableError
+	if err := <-results[2]; !errors.As(err, &unroutable) || unroutable.ReplyCode != 312 {
+		t.Errorf("got %v for the returned message", err)
+	}
+	if err := <-results[3]; !errors.Is(err, ErrNacked) {
+		t.Errorf("got %v for the nacked message", err)
+	}
+	for _, tag := range []uint64{1, 4} {
+		if err := <-results[tag]; err != nil {
+			t.Errorf("got %v for message %d", err, tag)
+		}
+	}
+}
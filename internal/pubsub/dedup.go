@@ -0,0 +1,245 @@
+package pubsub
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DedupStats counts what a Deduplicator has done so far.
+type DedupStats struct {
+	// Remembered is how many message IDs are currently remembered.
+	Remembered int
+	// Dropped is how many duplicates were acked without being handled.
+	Dropped uint64
+}
+
+// Deduplicator remembers the IDs of handled messages, so redelivered copies
+// can be skipped. It forgets the least recently handled IDs beyond its
+// capacity and, with a TTL, IDs older than that. Messages without an ID are
+// never deduplicated.
+type Deduplicator struct {
+	capacity int
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // of dedupEntry, most recent at the front
+	entries map[string]*list.Element
+	dropped uint64
+
+	// file, when set, has every remembered ID appended to it; lines counts
+	// them, to know when to compact.
+	path  string
+	file  *os.File
+	lines int
+}
+
+type dedupEntry struct {
+	key  string
+	seen time.Time
+}
+
+// NewDeduplicator keeps up to capacity IDs in memory. A ttl of 0 keeps them
+// until they are pushed out.
+func NewDeduplicator(capacity int, ttl time.Duration) *Deduplicator {
+	return &Deduplicator{
+		capacity: max(capacity, 1),
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+// OpenDeduplicator is NewDeduplicator backed by a file at path, so handled
+// messages are still recognized after a restart. IDs saved by an earlier run
+// are loaded first.
+func OpenDeduplicator(path string, capacity int, ttl time.Duration) (*Deduplicator, error) {
+	d := NewDeduplicator(capacity, ttl)
+	d.path = path
+	err := d.load()
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s: %v", path, err)
+	}
+	err = d.compact()
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// WithDedup acks messages d has already seen handled on this queue without
+// handing them to the handler again, see Dedup. Only messages the handler
+// acked are remembered, so retried and requeued ones still come through.
+func WithDedup(d *Deduplicator) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.dedup = d
+	}
+}
+
+// Stats returns the deduplicator's counters.
+func (d *Deduplicator) Stats() DedupStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return DedupStats{
+		Remembered: d.order.Len(),
+		Dropped:    d.dropped,
+	}
+}
+
+// Close closes the backing file, if any.
+func (d *Deduplicator) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.file == nil {
+		return nil
+	}
+	err := d.file.Close()
+	d.file = nil
+	return err
+}
+
+// drop reports whether the message is a duplicate, and counts it if so.
+func (d *Deduplicator) drop(queueName string, m Metadata) bool {
+	if d == nil || m.MessageID == "" {
+		return false
+	}
+	key := dedupKey(queueName, m.MessageID)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.entries[key]
+	if !ok {
+		return false
+	}
+	if d.expired(e.Value.(dedupEntry).seen, time.Now()) {
+		d.order.Remove(e)
+		delete(d.entries, key)
+		return false
+	}
+	d.dropped++
+	return true
+}
+
+// remember records that the message was handled.
+func (d *Deduplicator) remember(queueName string, m Metadata) {
+	if d == nil || m.MessageID == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := dedupKey(queueName, m.MessageID)
+	now := time.Now()
+	d.add(key, now)
+	if d.file == nil {
+		return
+	}
+	_, err := fmt.Fprintf(d.file, "%d %s\n", now.UnixNano(), key)
+	if err != nil {
+		fmt.Printf("Error saving handled message ID: %v\n", err)
+		return
+	}
+	d.lines++
+	// Everything past the capacity is dead weight in the file.
+	if d.lines > 2*d.capacity {
+		err := d.compact()
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// add moves key to the front, evicting the oldest entries beyond capacity.
+// d.mu must be held.
+func (d *Deduplicator) add(key string, seen time.Time) {
+	if e, ok := d.entries[key]; ok {
+		d.order.Remove(e)
+	}
+	d.entries[key] = d.order.PushFront(dedupEntry{key: key, seen: seen})
+	for d.order.Len() > d.capacity {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.entries, oldest.Value.(dedupEntry).key)
+	}
+}
+
+func (d *Deduplicator) expired(seen, now time.Time) bool {
+	return d.ttl > 0 && now.Sub(seen) > d.ttl
+}
+
+func (d *Deduplicator) load() error {
+	f, err := os.Open(d.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := time.Now()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		nanos, key, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(nanos, 10, 64)
+		if err != nil {
+			continue
+		}
+		seen := time.Unix(0, n)
+		if !d.expired(seen, now) {
+			d.add(key, seen)
+		}
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the file with only the remembered IDs, oldest first, and
+// reopens it for appending. d.mu must be held, or d not shared yet.
+func (d *Deduplicator) compact() error {
+	tmp := d.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("error compacting %s: %v", d.path, err)
+	}
+	w := bufio.NewWriter(f)
+	for e := d.order.Back(); e != nil; e = e.Prev() {
+		entry := e.Value.(dedupEntry)
+		fmt.Fprintf(w, "%d %s\n", entry.seen.UnixNano(), entry.key)
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp, d.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error compacting %s: %v", d.path, err)
+	}
+
+	if d.file != nil {
+		d.file.Close()
+	}
+	d.file, err = os.OpenFile(d.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		d.file = nil
+		return fmt.Errorf("error opening %s: %v", d.path, err)
+	}
+	d.lines = d.order.Len()
+	return nil
+}
+
+// dedupKey keeps queues apart: a message fanned out to several queues is
+// handled once on each.
+func dedupKey(queueName, messageID string) string {
+	return queueName + "/" + messageID
+}
@@ -0,0 +1,99 @@
+package pubsub_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+// dedupHandler counts how often it handles a message and settles each with
+// whatever is next in results, or Ack.
+func dedupHandler(handled *int, results ...pubsub.AckType) pubsub.Handler {
+	return func(context.Context, pubsub.Delivery) pubsub.AckType {
+		*handled++
+		if len(results) == 0 {
+			return pubsub.Ack
+		}
+		ackType := results[0]
+		results = results[1:]
+		return ackType
+	}
+}
+
+func withID(id string) pubsub.Delivery {
+	return pubsub.Delivery{Metadata: pubsub.Metadata{MessageID: id}}
+}
+
+func TestDedup(t *testing.T) {
+	d := pubsub.NewDeduplicator(2, 0)
+	handled := 0
+	moves := pubsub.Dedup(d, "moves")(dedupHandler(&handled, pubsub.NackRequeue))
+	ctx := context.Background()
+
+	// A requeued message isn't remembered, so its redelivery is handled.
+	moves(ctx, withID("a"))
+	moves(ctx, withID("a"))
+	if got := moves(ctx, withID("a")); got != pubsub.Ack || handled != 2 {
+		t.Errorf("got %v after handling %d times, want the third copy acked unhandled", got, handled)
+	}
+
+	// Queues are deduplicated separately, and messages without an ID not
+	// at all.
+	wars := pubsub.Dedup(d, "wars")(dedupHandler(&handled))
+	wars(ctx, withID("a"))
+	moves(ctx, withID(""))
+	moves(ctx, withID(""))
+	if handled != 5 {
+		t.Errorf("handled %d messages, want 5", handled)
+	}
+
+	// Only the two most recent IDs are kept.
+	moves(ctx, withID("b"))
+	moves(ctx, withID("a"))
+	if handled != 7 {
+		t.Errorf("handled %d messages, want a forgotten ID handled again", handled)
+	}
+	if stats := d.Stats(); stats.Remembered != 2 || stats.Dropped != 1 {
+		t.Errorf("got stats %+v", stats)
+	}
+}
+
+func TestDedupTTL(t *testing.T) {
+	d := pubsub.NewDeduplicator(10, 10*time.Millisecond)
+	handled := 0
+	h := pubsub.Dedup(d, "moves")(dedupHandler(&handled))
+	h(context.Background(), withID("a"))
+	time.Sleep(20 * time.Millisecond)
+	h(context.Background(), withID("a"))
+	if handled != 2 {
+		t.Errorf("handled %d times, want the expired ID handled again", handled)
+	}
+}
+
+func TestDedupFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.log")
+	d, err := pubsub.OpenDeduplicator(path, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handled := 0
+	pubsub.Dedup(d, "moves")(dedupHandler(&handled))(context.Background(), withID("a"))
+	err = d.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted consumer still knows what it handled.
+	d, err = pubsub.OpenDeduplicator(path, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	pubsub.Dedup(d, "moves")(dedupHandler(&handled))(context.Background(), withID("a"))
+	if handled != 1 {
+		t.Errorf("handled %d times, want the copy dropped after a restart", handled)
+	}
+}
@@ -0,0 +1,179 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// CurrentSchemaVersion is the version of the message types in routing and
+// gamelogic. Bump it when a change would confuse older consumers.
+const CurrentSchemaVersion = 1
+
+// Headers for the metadata AMQP has no property for.
+const (
+	SchemaVersionHeader = "x-schema-version"
+	CausationIDHeader   = "x-causation-id"
+)
+
+// Metadata is the envelope a message travels in.
+type Metadata struct {
+	MessageID string
+	// Timestamp only keeps whole seconds over AMQP.
+	Timestamp time.Time
+	// AppID names the program that published the message, e.g. peril-client.
+	AppID string
+	// UserID is the player the message comes from. It goes in AMQP's
+	// user-id property, which RabbitMQ only lets through when it matches the
+	// connection's login, or when the login has the impersonator tag, like
+	// the gateway's. Consumers can trust it as far as they trust those.
+	UserID        string
+	SchemaVersion int
+	// CorrelationID is shared by every message in a conversation, and
+	// CausationID is the ID of the message this one answers.
+	CorrelationID string
+	CausationID   string
+}
+
+// EnvelopePublisher fills in the metadata of every message it publishes. A
+// message published with the context a Subscribe handler got is caused by
+// that delivery and continues its conversation.
+type EnvelopePublisher struct {
+	pub    Publisher
+	appID  string
+	userID string
+}
+
+func NewEnvelopePublisher(pub Publisher, appID, userID string) *EnvelopePublisher {
+	return &EnvelopePublisher{
+		pub:    pub,
+		appID:  appID,
+		userID: userID,
+	}
+}
+
+// Publish stamps the fields msg leaves empty and publishes it.
+func (p *EnvelopePublisher) Publish(ctx context.Context, exchange, routingKey string, msg Publishing) error {
+	m := &msg.Metadata
+	if m.MessageID == "" {
+		m.MessageID = NewMessageID()
+	}
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now()
+	}
+	if m.AppID == "" {
+		m.AppID = p.appID
+	}
+	if m.UserID == "" {
+		m.UserID = p.userID
+	}
+	if m.SchemaVersion == 0 {
+		m.SchemaVersion = CurrentSchemaVersion
+	}
+	cause, caused := MetadataFrom(ctx)
+	if m.CausationID == "" && caused {
+		m.CausationID = cause.MessageID
+	}
+	if m.CorrelationID == "" {
+		m.CorrelationID = correlationID(ctx)
+	}
+	return p.pub.Publish(ctx, exchange, routingKey, msg)
+}
+
+// NewMessageID returns a random 128-bit ID.
+func NewMessageID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type metadataKey struct{}
+
+type correlationKey struct{}
+
+// ContextWithMetadata returns a context for handling a delivery with metadata
+// m.
+func ContextWithMetadata(ctx context.Context, m Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, m)
+}
+
+// MetadataFrom returns the metadata of the delivery being handled with ctx.
+func MetadataFrom(ctx context.Context) (Metadata, bool) {
+	m, ok := ctx.Value(metadataKey{}).(Metadata)
+	return m, ok
+}
+
+// WithCorrelationID starts a conversation: messages published with the
+// returned context carry id as their correlation ID.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// correlationID continues the conversation ctx is part of. A delivery
+// without a correlation ID starts one named after itself.
+func correlationID(ctx context.Context) string {
+	if id, ok := ctx.Value(correlationKey{}).(string); ok {
+		return id
+	}
+	m, ok := MetadataFrom(ctx)
+	if !ok {
+		return ""
+	}
+	if m.CorrelationID != "" {
+		return m.CorrelationID
+	}
+	return m.MessageID
+}
+
+// setHeaders adds the metadata without an AMQP property to headers, creating
+// the map when needed.
+func (m Metadata) setHeaders(headers map[string]any) map[string]any {
+	if m.SchemaVersion == 0 && m.CausationID == "" {
+		return headers
+	}
+	if headers == nil {
+		headers = map[string]any{}
+	}
+	if m.SchemaVersion != 0 {
+		headers[SchemaVersionHeader] = int32(m.SchemaVersion)
+	}
+	if m.CausationID != "" {
+		headers[CausationIDHeader] = m.CausationID
+	}
+	return headers
+}
+
+// takeHeaders moves the metadata setHeaders added out of headers and into m.
+func (m *Metadata) takeHeaders(headers map[string]any) {
+	if v, ok := toInt(headers[SchemaVersionHeader]); ok {
+		m.SchemaVersion = v
+	}
+	m.CausationID = headerString(headers[CausationIDHeader])
+	delete(headers, SchemaVersionHeader)
+	delete(headers, CausationIDHeader)
+}
+
+func headerString(v any) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return ""
+}
+
+// unixString and parseUnix encode timestamps for STOMP headers.
+func unixString(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func parseUnix(s string) time.Time {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(n, 0)
+}
@@ -0,0 +1,72 @@
+package pubsub_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// The envelope has to survive a trip through STOMP headers, and a reply
+// published with the handler's context continues the conversation.
+func TestEnvelope(t *testing.T) {
+	broker, _ := startSTOMP(t)
+	client := pubsub.NewEnvelopePublisher(broker, "peril-client", "alice")
+	server := pubsub.NewEnvelopePublisher(broker, "peril-server", "")
+
+	sub, err := pubsub.Subscribe(broker, routing.ExchangePerilTopic, "ping.test", "ping.*", pubsub.TransientQueue, func(ctx context.Context, d pubsub.Message[string]) pubsub.AckType {
+		err := pubsub.Publish(ctx, server, pubsub.JSON, routing.ExchangePerilTopic, "pong.alice", "pong")
+		if err != nil {
+			return pubsub.NackDiscard
+		}
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+	pongs := make(chan pubsub.Message[string], 2)
+	sub, err = pubsub.Subscribe(broker, routing.ExchangePerilTopic, "pong.test", "pong.*", pubsub.TransientQueue, func(_ context.Context, d pubsub.Message[string]) pubsub.AckType {
+		pongs <- d
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+
+	start := time.Now().Truncate(time.Second)
+	ping := pubsub.Publishing{Metadata: pubsub.Metadata{MessageID: "ping-1"}, ContentType: "application/json", Body: []byte(`"ping"`)}
+	err = client.Publish(context.Background(), routing.ExchangePerilTopic, "ping.alice", ping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pong := receive(t, pongs)
+	m := pong.Metadata
+	if m.MessageID == "" || m.MessageID == "ping-1" {
+		t.Errorf("pong has message ID %q", m.MessageID)
+	}
+	if m.Timestamp.Before(start) {
+		t.Errorf("pong is timestamped %v, before it was sent", m.Timestamp)
+	}
+	if m.AppID != "peril-server" || m.UserID != "" || m.SchemaVersion != pubsub.CurrentSchemaVersion {
+		t.Errorf("got envelope %+v", m)
+	}
+	// The ping had no correlation ID, so it started a conversation of its
+	// own.
+	if m.CausationID != "ping-1" || m.CorrelationID != "ping-1" {
+		t.Errorf("pong has causation %q and correlation %q, want both ping-1", m.CausationID, m.CorrelationID)
+	}
+
+	ctx := pubsub.WithCorrelationID(context.Background(), "game-1")
+	ping.Metadata.MessageID = "ping-2"
+	err = client.Publish(ctx, routing.ExchangePerilTopic, "ping.alice", ping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := receive(t, pongs).Metadata; m.CorrelationID != "game-1" {
+		t.Errorf("pong has correlation %q, want game-1", m.CorrelationID)
+	}
+}
@@ -0,0 +1,475 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryBroker is an in-process stand-in for RabbitMQ. It keeps exchanges,
+// queues and bindings in memory and follows the same routing, acknowledgement
+// and dead-lettering rules, so the game can run without a live server.
+// Clients talk to it through connections obtained from Connect.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	exchanges map[string]*memExchange
+	queues    map[string]*memQueue
+}
+
+type memExchange struct {
+	kind     ExchangeKind
+	bindings []memBinding
+}
+
+type memBinding struct {
+	key   string
+	queue *memQueue
+}
+
+type memQueue struct {
+	name                 string
+	durable              bool
+	owner                *MemoryConn
+	ttl                  time.Duration
+	deadLetter           bool
+	deadLetterExchange   string
+	deadLetterRoutingKey string
+	messages             []memMessage
+	cond                 *sync.Cond
+	deleted              bool
+}
+
+type memMessage struct {
+	d       Delivery
+	expires time.Time
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		exchanges: map[string]*memExchange{},
+		queues:    map[string]*memQueue{},
+	}
+}
+
+// MemoryConn is a single client connection to a MemoryBroker. Transient
+// queues are exclusive to the connection that declared them and are deleted
+// when it is closed.
+type MemoryConn struct {
+	broker *MemoryBroker
+	login  string
+	closed bool
+	queues []*memQueue
+}
+
+// Connect opens a connection that may publish as any user, like a RabbitMQ
+// login with the impersonator tag.
+func (b *MemoryBroker) Connect() *MemoryConn {
+	return &MemoryConn{broker: b}
+}
+
+// ConnectAs opens a connection logged in as login. Like RabbitMQ, it refuses
+// to publish messages whose user ID is anybody else's.
+func (b *MemoryBroker) ConnectAs(login string) *MemoryConn {
+	return &MemoryConn{broker: b, login: login}
+}
+
+func (c *MemoryConn) DeclareExchange(name string, kind ExchangeKind) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+
+	switch kind {
+	case ExchangeKindDirect, ExchangeKindTopic, ExchangeKindFanout:
+	default:
+		return fmt.Errorf("error declaring exchange: unknown kind %q", kind)
+	}
+	if ex, ok := b.exchanges[name]; ok {
+		if ex.kind != kind {
+			return fmt.Errorf("error declaring exchange: %s already declared as %s", name, ex.kind)
+		}
+		return nil
+	}
+	b.exchanges[name] = &memExchange{kind: kind}
+	return nil
+}
+
+func (c *MemoryConn) DeclareAndBind(exchange, queueName, bindingKey string, simpleQueueType QueueType) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+
+	ex, ok := b.exchanges[exchange]
+	if !ok {
+		return fmt.Errorf("error binding queue: no exchange %s", exchange)
+	}
+
+	durable := simpleQueueType == DurableQueue
+	q, ok := b.queues[queueName]
+	if ok {
+		if q.durable != durable {
+			return fmt.Errorf("error declaring queue: %s already declared with different durability", queueName)
+		}
+		if q.owner != nil && q.owner != c {
+			return fmt.Errorf("error declaring queue: %s is exclusive to another connection", queueName)
+		}
+	} else {
+		q = &memQueue{
+			name:               queueName,
+			durable:            durable,
+			deadLetter:         true,
+			deadLetterExchange: DeadLetterExchange,
+			cond:               sync.NewCond(&b.mu),
+		}
+		if !durable {
+			q.owner = c
+			c.queues = append(c.queues, q)
+		}
+		b.queues[queueName] = q
+	}
+
+	for _, binding := range ex.bindings {
+		if binding.queue == q && binding.key == bindingKey {
+			return nil
+		}
+	}
+	ex.bindings = append(ex.bindings, memBinding{key: bindingKey, queue: q})
+	return nil
+}
+
+func (c *MemoryConn) DeclareQueue(name string, opts QueueOptions) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+
+	if q, ok := b.queues[name]; ok {
+		if q.durable != opts.Durable || q.ttl != opts.MessageTTL || (q.owner != nil) != opts.Exclusive {
+			return fmt.Errorf("error declaring queue: %s already declared with different options", name)
+		}
+		if q.owner != nil && q.owner != c {
+			return fmt.Errorf("error declaring queue: %s is exclusive to another connection", name)
+		}
+		return nil
+	}
+	q := &memQueue{
+		name:                 name,
+		durable:              opts.Durable,
+		ttl:                  opts.MessageTTL,
+		deadLetter:           opts.DeadLetterExchange != "" || opts.DeadLetterRoutingKey != "",
+		deadLetterExchange:   opts.DeadLetterExchange,
+		deadLetterRoutingKey: opts.DeadLetterRoutingKey,
+		cond:                 sync.NewCond(&b.mu),
+	}
+	if opts.Exclusive {
+		q.owner = c
+		c.queues = append(c.queues, q)
+	}
+	b.queues[name] = q
+	return nil
+}
+
+func (c *MemoryConn) BindQueue(queueName, exchange, bindingKey string) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+
+	ex, ok := b.exchanges[exchange]
+	if !ok {
+		return fmt.Errorf("error binding queue: no exchange %s", exchange)
+	}
+	q, ok := b.queues[queueName]
+	if !ok {
+		return fmt.Errorf("error binding queue: no queue %s", queueName)
+	}
+	for _, binding := range ex.bindings {
+		if binding.queue == q && binding.key == bindingKey {
+			return nil
+		}
+	}
+	ex.bindings = append(ex.bindings, memBinding{key: bindingKey, queue: q})
+	return nil
+}
+
+func (c *MemoryConn) Publish(ctx context.Context, exchange, routingKey string, msg Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("error publishing message: %v", err)
+	}
+
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+
+	if _, ok := b.exchanges[exchange]; !ok && exchange != "" {
+		return fmt.Errorf("error publishing message: no exchange %s", exchange)
+	}
+	if c.login != "" && msg.Metadata.UserID != "" && msg.Metadata.UserID != c.login {
+		return fmt.Errorf("error publishing message: user ID %s does not match the login %s", msg.Metadata.UserID, c.login)
+	}
+	b.publish(Delivery{
+		Metadata:    msg.Metadata,
+		Exchange:    exchange,
+		RoutingKey:  routingKey,
+		ContentType: msg.ContentType,
+		Headers:     maps.Clone(msg.Headers),
+		Body:        msg.Body,
+	})
+	return nil
+}
+
+func (c *MemoryConn) Consume(queueName string, prefetch int, handler func(Delivery) AckType) (*Subscription, error) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	q, ok := b.queues[queueName]
+	if !ok {
+		return nil, fmt.Errorf("error consuming: no queue %s", queueName)
+	}
+	if q.owner != nil && q.owner != c {
+		return nil, fmt.Errorf("error consuming: %s is exclusive to another connection", queueName)
+	}
+	c.queues = append(c.queues, q)
+
+	cancelled := false
+	sub := newSubscription(func() error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		cancelled = true
+		q.cond.Broadcast()
+		return nil
+	})
+
+	go func() {
+		for {
+			b.mu.Lock()
+			b.expire(q)
+			for len(q.messages) == 0 && !q.deleted && !c.closed && !cancelled {
+				q.cond.Wait()
+				b.expire(q)
+			}
+			switch {
+			case cancelled:
+				b.mu.Unlock()
+				sub.finish(nil)
+				return
+			case c.closed:
+				b.mu.Unlock()
+				sub.finish(ErrClosed)
+				return
+			case q.deleted:
+				b.mu.Unlock()
+				sub.finish(fmt.Errorf("queue %s was deleted", q.name))
+				return
+			}
+			d := q.messages[0].d
+			q.messages = q.messages[1:]
+			b.mu.Unlock()
+
+			ackType := settleWithoutRetry(handler(d))
+
+			b.mu.Lock()
+			switch ackType {
+			case NackRequeue:
+				d.Redelivered = true
+				q.messages = append([]memMessage{{d: d}}, q.messages...)
+				q.cond.Signal()
+			case NackDiscard:
+				b.deadLetter(q, d, "rejected")
+			}
+			b.mu.Unlock()
+		}
+	}()
+
+	return sub, nil
+}
+
+// Close stops every consumer started on this connection and deletes the
+// transient queues it owns. Durable queues keep collecting messages.
+func (c *MemoryConn) Close() error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	for _, q := range c.queues {
+		if q.owner == c && !q.deleted {
+			b.deleteQueue(q)
+		}
+		q.cond.Broadcast()
+	}
+	c.queues = nil
+	return nil
+}
+
+// publish routes d through its exchange. The default exchange routes straight
+// to the queue named by the routing key. b.mu must be held.
+func (b *MemoryBroker) publish(d Delivery) {
+	if d.Exchange == "" {
+		if q, ok := b.queues[d.RoutingKey]; ok {
+			b.enqueue(q, d)
+		}
+		return
+	}
+	ex, ok := b.exchanges[d.Exchange]
+	if !ok {
+		return
+	}
+	seen := map[*memQueue]struct{}{}
+	for _, binding := range ex.bindings {
+		if _, ok := seen[binding.queue]; ok {
+			continue
+		}
+		if !ex.matches(binding.key, d.RoutingKey) {
+			continue
+		}
+		seen[binding.queue] = struct{}{}
+		b.enqueue(binding.queue, d)
+	}
+}
+
+// deadLetter republishes a discarded or expired message to the queue's dead
+// letter exchange and records it in the x-death header the way RabbitMQ does.
+// b.mu must be held.
+func (b *MemoryBroker) deadLetter(q *memQueue, d Delivery, reason string) {
+	if !q.deadLetter {
+		return
+	}
+	d.Headers = withDeath(d.Headers, q.name, reason, d.Exchange, d.RoutingKey)
+	d.Exchange = q.deadLetterExchange
+	if q.deadLetterRoutingKey != "" {
+		d.RoutingKey = q.deadLetterRoutingKey
+	}
+	d.Redelivered = false
+	b.publish(d)
+}
+
+// enqueue appends d to q and, for queues with a TTL, schedules its expiry.
+// b.mu must be held.
+func (b *MemoryBroker) enqueue(q *memQueue, d Delivery) {
+	m := memMessage{d: d}
+	if q.ttl > 0 {
+		m.expires = time.Now().Add(q.ttl)
+		time.AfterFunc(q.ttl, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.expire(q)
+		})
+	}
+	q.messages = append(q.messages, m)
+	q.cond.Signal()
+}
+
+// expire dead-letters messages at the head of q whose TTL has passed. Like
+// RabbitMQ, only the head is checked. b.mu must be held.
+func (b *MemoryBroker) expire(q *memQueue) {
+	now := time.Now()
+	for len(q.messages) > 0 && !q.messages[0].expires.IsZero() && !now.Before(q.messages[0].expires) {
+		d := q.messages[0].d
+		q.messages = q.messages[1:]
+		b.deadLetter(q, d, "expired")
+	}
+}
+
+func withDeath(headers map[string]any, queue, reason, exchange, routingKey string) map[string]any {
+	headers = maps.Clone(headers)
+	if headers == nil {
+		headers = map[string]any{}
+	}
+	deaths, _ := headers["x-death"].([]any)
+	deaths = slices.Clone(deaths)
+	for i, death := range deaths {
+		entry, ok := death.(map[string]any)
+		if !ok || entry["queue"] != queue || entry["reason"] != reason {
+			continue
+		}
+		entry = maps.Clone(entry)
+		count, _ := entry["count"].(int64)
+		entry["count"] = count + 1
+		deaths[i] = entry
+		headers["x-death"] = deaths
+		return headers
+	}
+	// newest deaths go first
+	headers["x-death"] = append([]any{map[string]any{
+		"queue":        queue,
+		"reason":       reason,
+		"exchange":     exchange,
+		"routing-keys": []any{routingKey},
+		"count":        int64(1),
+	}}, deaths...)
+	return headers
+}
+
+// deleteQueue unbinds q from every exchange and wakes its consumers so they
+// can exit. b.mu must be held.
+func (b *MemoryBroker) deleteQueue(q *memQueue) {
+	q.deleted = true
+	q.messages = nil
+	delete(b.queues, q.name)
+	for _, ex := range b.exchanges {
+		bindings := ex.bindings[:0]
+		for _, binding := range ex.bindings {
+			if binding.queue != q {
+				bindings = append(bindings, binding)
+			}
+		}
+		ex.bindings = bindings
+	}
+	q.cond.Broadcast()
+}
+
+func (ex *memExchange) matches(bindingKey, routingKey string) bool {
+	switch ex.kind {
+	case ExchangeKindFanout:
+		return true
+	case ExchangeKindTopic:
+		return topicMatch(strings.Split(bindingKey, "."), strings.Split(routingKey, "."))
+	default:
+		return bindingKey == routingKey
+	}
+}
+
+// topicMatch reports whether a routing key matches a topic binding pattern,
+// where "*" stands for exactly one word and "#" for zero or more words.
+func topicMatch(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatch(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatch(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && topicMatch(pattern[1:], words[1:])
+	}
+}
@@ -0,0 +1,381 @@
+package pubsub_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topology"
+)
+
+// startMemory connects to a fresh MemoryBroker with the Peril topology
+// applied.
+func startMemory(t *testing.T) (*pubsub.MemoryBroker, *pubsub.MemoryConn) {
+	t.Helper()
+	broker := pubsub.NewMemoryBroker()
+	conn := broker.Connect()
+	t.Cleanup(func() { conn.Close() })
+	err := topology.Peril().Apply(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return broker, conn
+}
+
+// consume collects every delivery on queueName, settling each with ackType.
+func consume(t *testing.T, conn *pubsub.MemoryConn, queueName string, ackType pubsub.AckType) <-chan pubsub.Delivery {
+	t.Helper()
+	got := make(chan pubsub.Delivery, 8)
+	sub, err := conn.Consume(queueName, 0, func(d pubsub.Delivery) pubsub.AckType {
+		got <- d
+		return ackType
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+	return got
+}
+
+func publishRaw(t *testing.T, conn *pubsub.MemoryConn, exchange, key, body string) {
+	t.Helper()
+	err := conn.Publish(context.Background(), exchange, key, pubsub.Publishing{Body: []byte(body)})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryTopicBindings(t *testing.T) {
+	tests := []struct {
+		binding, key string
+		want         bool
+	}{
+		{"army_moves.*", "army_moves.alice", true},
+		{"army_moves.*", "army_moves", false},
+		{"army_moves.*", "army_moves.alice.bob", false},
+		{"*.alice", "army_moves.alice", true},
+		{"#", "army_moves.alice.bob", true},
+		{"#", "", true},
+		{"army_moves.#", "army_moves", true},
+		{"army_moves.#", "army_moves.alice.bob", true},
+		{"army_moves.#", "war.alice", false},
+		{"#.bob", "army_moves.alice.bob", true},
+		{"#.bob", "bob", true},
+		{"#.bob", "army_moves.bob.alice", false},
+		{"army_moves.#.bob", "army_moves.bob", true},
+		{"*.*.#", "army_moves", false},
+		{"pause", "pause", true},
+		{"pause", "paused", false},
+	}
+	for _, tt := range tests {
+		_, conn := startMemory(t)
+		err := conn.DeclareAndBind(routing.ExchangePerilTopic, "test", tt.binding, pubsub.TransientQueue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := consume(t, conn, "test", pubsub.Ack)
+		publishRaw(t, conn, routing.ExchangePerilTopic, tt.key, "")
+		if tt.want {
+			receive(t, got)
+		} else {
+			expectNothing(t, got)
+		}
+	}
+}
+
+func TestMemoryQueueDurability(t *testing.T) {
+	broker, conn := startMemory(t)
+	err := conn.DeclareAndBind(routing.ExchangePerilTopic, "durable", routing.GameLogSlug+".*", pubsub.DurableQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.DeclareAndBind(routing.ExchangePerilTopic, "transient", routing.GameLogSlug+".*", pubsub.TransientQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Transient queues are exclusive to the connection that declared them.
+	other := broker.Connect()
+	t.Cleanup(func() { other.Close() })
+	_, err = other.Consume("transient", 0, func(pubsub.Delivery) pubsub.AckType { return pubsub.Ack })
+	if err == nil {
+		t.Error("consumed another connection's transient queue")
+	}
+	err = other.DeclareAndBind(routing.ExchangePerilTopic, "durable", routing.GameLogSlug+".*", pubsub.TransientQueue)
+	if err == nil {
+		t.Error("redeclared a durable queue as transient")
+	}
+
+	publishRaw(t, other, routing.ExchangePerilTopic, routing.GameLogSlug+".alice", "kept")
+	err = conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The durable queue and its message outlive the connection, the
+	// transient one doesn't.
+	_, err = other.Consume("transient", 0, func(pubsub.Delivery) pubsub.AckType { return pubsub.Ack })
+	if err == nil {
+		t.Error("transient queue survived its connection")
+	}
+	d := receive(t, consume(t, other, "durable", pubsub.Ack))
+	if string(d.Body) != "kept" {
+		t.Errorf("got %q from the durable queue", d.Body)
+	}
+
+	_, err = conn.Consume("durable", 0, func(pubsub.Delivery) pubsub.AckType { return pubsub.Ack })
+	if !errors.Is(err, pubsub.ErrClosed) {
+		t.Errorf("got %v consuming on a closed connection, want ErrClosed", err)
+	}
+}
+
+func TestMemoryAck(t *testing.T) {
+	_, conn := startMemory(t)
+	got := make(chan pubsub.Delivery, 2)
+	sub, err := conn.Consume(routing.GameLogSlug, 0, func(d pubsub.Delivery) pubsub.AckType {
+		got <- d
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publishRaw(t, conn, routing.ExchangePerilTopic, routing.GameLogSlug+".alice", "once")
+	receive(t, got)
+	err = sub.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectNothing(t, consume(t, conn, routing.GameLogSlug, pubsub.Ack))
+}
+
+func TestMemoryNackRequeue(t *testing.T) {
+	_, conn := startMemory(t)
+	got := make(chan pubsub.Delivery, 4)
+	sub, err := conn.Consume(routing.GameLogSlug, 0, func(d pubsub.Delivery) pubsub.AckType {
+		got <- d
+		if !d.Redelivered {
+			return pubsub.NackRequeue
+		}
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+
+	publishRaw(t, conn, routing.ExchangePerilTopic, routing.GameLogSlug+".alice", "first")
+	publishRaw(t, conn, routing.ExchangePerilTopic, routing.GameLogSlug+".alice", "second")
+	// A requeued message goes back to the head of the queue.
+	for _, want := range []struct {
+		body        string
+		redelivered bool
+	}{
+		{"first", false},
+		{"first", true},
+		{"second", false},
+		{"second", true},
+	} {
+		d := receive(t, got)
+		if string(d.Body) != want.body || d.Redelivered != want.redelivered {
+			t.Errorf("got %q redelivered %v, want %q redelivered %v", d.Body, d.Redelivered, want.body, want.redelivered)
+		}
+	}
+	expectNothing(t, got)
+}
+
+func TestMemoryNackDiscard(t *testing.T) {
+	_, conn := startMemory(t)
+	dead := consume(t, conn, routing.QueuePerilDLQ, pubsub.Ack)
+	got := consume(t, conn, routing.GameLogSlug, pubsub.NackDiscard)
+
+	publishRaw(t, conn, routing.ExchangePerilTopic, routing.GameLogSlug+".alice", "bad")
+	receive(t, got)
+	expectNothing(t, got)
+
+	d := receive(t, dead)
+	if string(d.Body) != "bad" || d.Exchange != routing.ExchangePerilDLX || d.Redelivered {
+		t.Errorf("dead-lettered %q from %s, redelivered %v", d.Body, d.Exchange, d.Redelivered)
+	}
+	deaths, _ := d.Headers["x-death"].([]any)
+	if len(deaths) != 1 {
+		t.Fatalf("got x-death %v, want one entry", d.Headers["x-death"])
+	}
+	death := deaths[0].(map[string]any)
+	if death["queue"] != routing.GameLogSlug || death["reason"] != "rejected" ||
+		death["exchange"] != routing.ExchangePerilTopic || death["count"] != int64(1) {
+		t.Errorf("got x-death %v", death)
+	}
+	keys, _ := death["routing-keys"].([]any)
+	if len(keys) != 1 || keys[0] != routing.GameLogSlug+".alice" {
+		t.Errorf("got routing keys %v", death["routing-keys"])
+	}
+}
+
+func TestMemoryDeadLetterCount(t *testing.T) {
+	_, conn := startMemory(t)
+	err := conn.DeclareQueue("again", pubsub.QueueOptions{
+		Durable:              true,
+		DeadLetterExchange:   routing.ExchangePerilDirect,
+		DeadLetterRoutingKey: "again",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.BindQueue("again", routing.ExchangePerilDirect, "again")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Dead-lettering back into the same queue counts up the existing
+	// x-death entry instead of adding another.
+	got := make(chan pubsub.Delivery, 4)
+	handled := 0
+	sub, err := conn.Consume("again", 0, func(d pubsub.Delivery) pubsub.AckType {
+		got <- d
+		handled++
+		if handled < 3 {
+			return pubsub.NackDiscard
+		}
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+	publishRaw(t, conn, routing.ExchangePerilDirect, "again", "loop")
+
+	receive(t, got)
+	receive(t, got)
+	d := receive(t, got)
+	deaths, _ := d.Headers["x-death"].([]any)
+	if len(deaths) != 1 {
+		t.Fatalf("got x-death %v, want one entry", d.Headers["x-death"])
+	}
+	if count := deaths[0].(map[string]any)["count"]; count != int64(2) {
+		t.Errorf("got count %v, want 2", count)
+	}
+}
+
+func TestMemoryRetryQueuesFollowTheirQueue(t *testing.T) {
+	broker, conn := startMemory(t)
+	sub, err := pubsub.Subscribe(conn, routing.ExchangePerilTopic, "client", routing.GameLogSlug+".*", pubsub.TransientQueue, func(context.Context, pubsub.Message[routing.GameLog]) pubsub.AckType {
+		return pubsub.Ack
+	}, pubsub.WithRetry(pubsub.RetryPolicy{MaxAttempts: 2, InitialDelay: time.Second}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+
+	other := broker.Connect()
+	t.Cleanup(func() { other.Close() })
+	for _, name := range []string{"client.retry.1000", "client.parking"} {
+		_, err := other.Consume(name, 0, func(pubsub.Delivery) pubsub.AckType { return pubsub.Ack })
+		if err == nil {
+			t.Errorf("%s isn't exclusive", name)
+		}
+	}
+	conn.Close()
+	for _, name := range []string{"client.retry.1000", "client.parking"} {
+		err := other.DeclareQueue(name, pubsub.QueueOptions{Durable: true})
+		if err != nil {
+			t.Errorf("%s outlived its connection: %v", name, err)
+		}
+	}
+}
+
+func TestMemoryUserID(t *testing.T) {
+	broker, conn := startMemory(t)
+	got := consume(t, conn, routing.GameLogSlug, pubsub.Ack)
+
+	alice := broker.ConnectAs("alice")
+	t.Cleanup(func() { alice.Close() })
+	publish := func(userID string) error {
+		return alice.Publish(context.Background(), routing.ExchangePerilTopic, routing.GameLogSlug+".alice", pubsub.Publishing{
+			Metadata: pubsub.Metadata{UserID: userID},
+		})
+	}
+
+	// Only the login's own user ID goes through, or none at all.
+	if err := publish("bob"); err == nil {
+		t.Error("alice published as bob")
+	}
+	expectNothing(t, got)
+	for _, userID := range []string{"alice", ""} {
+		if err := publish(userID); err != nil {
+			t.Fatalf("error publishing as %q: %v", userID, err)
+		}
+		if d := receive(t, got); d.Metadata.UserID != userID {
+			t.Errorf("got user ID %q, want %q", d.Metadata.UserID, userID)
+		}
+	}
+}
+
+func TestMemoryRetryForwardsUserID(t *testing.T) {
+	broker, _ := startMemory(t)
+	server := broker.ConnectAs("server")
+	t.Cleanup(func() { server.Close() })
+
+	got := make(chan pubsub.Message[routing.GameLog], 2)
+	sub, err := pubsub.Subscribe(server, routing.ExchangePerilTopic, routing.GameLogSlug, routing.GameLogSlug+".*", pubsub.DurableQueue, func(_ context.Context, d pubsub.Message[routing.GameLog]) pubsub.AckType {
+		got <- d
+		if d.Headers[pubsub.RetryHeader] == nil {
+			return pubsub.NackRetry
+		}
+		return pubsub.Ack
+	}, pubsub.WithRetry(pubsub.RetryPolicy{InitialDelay: 10 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+
+	alice := broker.ConnectAs("alice")
+	t.Cleanup(func() { alice.Close() })
+	err = pubsub.Publish(context.Background(), pubsub.NewEnvelopePublisher(alice, "peril-client", "alice"), pubsub.JSON, routing.ExchangePerilTopic, routing.GameLogSlug+".alice", routing.GameLog{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server can't publish as alice, so the retry carries her name in
+	// a header instead.
+	if d := receive(t, got); d.Metadata.UserID != "alice" {
+		t.Errorf("got user ID %q, want alice", d.Metadata.UserID)
+	}
+	d := receive(t, got)
+	if d.Metadata.UserID != "" || d.Headers[pubsub.OriginalUserIDHeader] != "alice" {
+		t.Errorf("retried with user ID %q and headers %v", d.Metadata.UserID, d.Headers)
+	}
+}
+
+func TestMemoryConsumeWithoutRetry(t *testing.T) {
+	_, conn := startMemory(t)
+	dead := consume(t, conn, routing.QueuePerilDLQ, pubsub.Ack)
+
+	// Without a retry policy NackRetry requeues and NackPark discards.
+	got := make(chan pubsub.Delivery, 4)
+	sub, err := conn.Consume(routing.GameLogSlug, 0, func(d pubsub.Delivery) pubsub.AckType {
+		got <- d
+		if !d.Redelivered {
+			return pubsub.NackRetry
+		}
+		return pubsub.NackPark
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+
+	publishRaw(t, conn, routing.ExchangePerilTopic, routing.GameLogSlug+".alice", "again")
+	if d := receive(t, got); d.Redelivered {
+		t.Error("first delivery is redelivered")
+	}
+	if d := receive(t, got); !d.Redelivered {
+		t.Error("NackRetry didn't requeue")
+	}
+	if d := receive(t, dead); string(d.Body) != "again" {
+		t.Errorf("dead-lettered %q", d.Body)
+	}
+	expectNothing(t, got)
+}
@@ -0,0 +1,261 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Handler handles a delivery before it is decoded. Subscribe builds one out
+// of the typed handler and its middlewares.
+type Handler func(ctx context.Context, d Delivery) AckType
+
+// Middleware wraps a handler in behavior of its own, like logging or rate
+// limiting.
+type Middleware func(next Handler) Handler
+
+// Message is a delivery with its body decoded into Msg.
+type Message[T any] struct {
+	Delivery
+	Msg T
+}
+
+// Chain wraps h in mws, the first one outermost.
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// WithMiddleware adds middlewares to the subscription. They run inside the
+// default ones, in order, so they see what the handler returned before
+// retries and deduplication act on it.
+func WithMiddleware(mws ...Middleware) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.middlewares = append(o.middlewares, mws...)
+	}
+}
+
+func (a AckType) String() string {
+	switch a {
+	case Ack:
+		return "ack"
+	case NackRequeue:
+		return "requeue"
+	case NackDiscard:
+		return "discard"
+	case NackRetry:
+		return "retry"
+	case NackPark:
+		return "park"
+	}
+	return fmt.Sprintf("AckType(%d)", int(a))
+}
+
+// LogAcks prints how every message was settled. Every subscription uses it.
+func LogAcks(next Handler) Handler {
+	return func(ctx context.Context, d Delivery) AckType {
+		ackType := next(ctx, d)
+		switch ackType {
+		case Ack:
+			println("Acking message")
+		case NackRequeue:
+			println("Nacking message and requeueing")
+		case NackDiscard:
+			println("Nacking message and discarding")
+		case NackRetry:
+			println("Nacking message and retrying later")
+		case NackPark:
+			println("Nacking message and parking it")
+		}
+		return ackType
+	}
+}
+
+// Dedup acks messages d has seen handled on queueName before, and remembers
+// the ones next acks. WithDedup adds it to a subscription.
+func Dedup(d *Deduplicator, queueName string) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, delivery Delivery) AckType {
+			if d.drop(queueName, delivery.Metadata) {
+				fmt.Printf("Dropping duplicate message %s\n", delivery.Metadata.MessageID)
+				return Ack
+			}
+			ackType := next(ctx, delivery)
+			if ackType == Ack {
+				d.remember(queueName, delivery.Metadata)
+			}
+			return ackType
+		}
+	}
+}
+
+// Recover turns a panicking handler into NackDiscard, printing the stack,
+// rather than letting it take the whole process down. Every subscription
+// uses it.
+func Recover(next Handler) Handler {
+	return func(ctx context.Context, d Delivery) (ackType AckType) {
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Printf("Error: handler panicked on %s/%s: %v\n%s", d.Exchange, d.RoutingKey, r, debug.Stack())
+				ackType = NackDiscard
+			}
+		}()
+		return next(ctx, d)
+	}
+}
+
+// WithTimeout gives the handler timeout to settle each delivery, see
+// Timeout.
+func WithTimeout(timeout time.Duration, policy AckType) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.timeout = timeout
+		o.timeoutPolicy = policy
+	}
+}
+
+// Timeout cancels the handler's context after timeout and settles the
+// delivery with policy, e.g. NackRetry, if the handler hasn't returned by
+// then. Go can't stop the handler, so it keeps running in the background
+// with its result ignored: handlers that can take long should give up once
+// their context is done.
+func Timeout(timeout time.Duration, policy AckType) Middleware {
+	return func(next Handler) Handler {
+		// The handler runs on a goroutine of its own here, out of reach of
+		// any Recover further out.
+		next = Recover(next)
+		return func(ctx context.Context, d Delivery) AckType {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			done := make(chan AckType, 1)
+			go func() {
+				done <- next(ctx, d)
+			}()
+			select {
+			case ackType := <-done:
+				return ackType
+			case <-ctx.Done():
+				fmt.Printf("Error: handler for %s/%s timed out after %v\n", d.Exchange, d.RoutingKey, timeout)
+				return policy
+			}
+		}
+	}
+}
+
+// HandlerMetrics counts what a subscription's handler has done.
+type HandlerMetrics struct {
+	mu    sync.Mutex
+	stats HandlerStats
+}
+
+// HandlerStats is a copy of HandlerMetrics' counters. Settled counts
+// deliveries by how the handler settled them.
+type HandlerStats struct {
+	Handled     uint64
+	Settled     map[string]uint64
+	TotalTime   time.Duration
+	SlowestTime time.Duration
+	LastHandled time.Time
+	AverageTime time.Duration
+	InFlight    int
+}
+
+func (m *HandlerMetrics) Stats() HandlerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.stats
+	stats.Settled = map[string]uint64{}
+	for k, v := range m.stats.Settled {
+		stats.Settled[k] = v
+	}
+	if stats.Handled > 0 {
+		stats.AverageTime = stats.TotalTime / time.Duration(stats.Handled)
+	}
+	return stats
+}
+
+// Metrics counts deliveries and how long handling them took in m. Handlers
+// that panic are counted as settled with "panic".
+func Metrics(m *HandlerMetrics) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, d Delivery) AckType {
+			m.mu.Lock()
+			m.stats.InFlight++
+			m.mu.Unlock()
+
+			start := time.Now()
+			settled := "panic"
+			defer func() {
+				elapsed := time.Since(start)
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				m.stats.InFlight--
+				m.stats.Handled++
+				if m.stats.Settled == nil {
+					m.stats.Settled = map[string]uint64{}
+				}
+				m.stats.Settled[settled]++
+				m.stats.TotalTime += elapsed
+				m.stats.SlowestTime = max(m.stats.SlowestTime, elapsed)
+				m.stats.LastHandled = start
+			}()
+			ackType := next(ctx, d)
+			settled = ackType.String()
+			return ackType
+		}
+	}
+}
+
+// Trace logs every delivery with its envelope, so a conversation can be
+// followed from message to message by its correlation ID.
+func Trace(logger *log.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, d Delivery) AckType {
+			start := time.Now()
+			ackType := next(ctx, d)
+			m := d.Metadata
+			logger.Printf("%s/%s id=%s correlation=%s causation=%s from=%s/%s: %v in %v",
+				d.Exchange, d.RoutingKey, m.MessageID, m.CorrelationID, m.CausationID, m.AppID, m.UserID,
+				ackType, time.Since(start).Round(time.Microsecond))
+			return ackType
+		}
+	}
+}
+
+// RateLimit spaces out deliveries to at most perSecond, holding the next
+// one back until its turn comes. A flood then backs up in the queue, where
+// RabbitMQ can account for it, rather than in the handler. A perSecond of
+// zero or less is no limit at all.
+func RateLimit(perSecond float64) Middleware {
+	if perSecond <= 0 {
+		return func(h Handler) Handler { return h }
+	}
+	interval := time.Duration(float64(time.Second) / perSecond)
+	var mu sync.Mutex
+	var next time.Time
+	return func(h Handler) Handler {
+		return func(ctx context.Context, d Delivery) AckType {
+			mu.Lock()
+			now := time.Now()
+			at := next
+			if at.Before(now) {
+				at = now
+			}
+			next = at.Add(interval)
+			mu.Unlock()
+
+			if wait := at.Sub(now); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return NackRequeue
+				}
+			}
+			return h(ctx, d)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package pubsub_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+func TestRateLimit(t *testing.T) {
+	ack := func(context.Context, pubsub.Delivery) pubsub.AckType { return pubsub.Ack }
+	tests := []struct {
+		perSecond float64
+		min       time.Duration
+	}{
+		{0, 0},
+		{-1, 0},
+		{50, 2 * 20 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		h := pubsub.RateLimit(tt.perSecond)(ack)
+		start := time.Now()
+		for range 3 {
+			if got := h(context.Background(), pubsub.Delivery{}); got != pubsub.Ack {
+				t.Fatalf("RateLimit(%v) settled with %v", tt.perSecond, got)
+			}
+		}
+		if elapsed := time.Since(start); elapsed < tt.min {
+			t.Errorf("RateLimit(%v) took %v for 3 deliveries, want at least %v", tt.perSecond, elapsed, tt.min)
+		}
+	}
+
+	// A delivery waiting its turn goes back to the queue when the
+	// subscription stops.
+	h := pubsub.RateLimit(0.001)(ack)
+	h(context.Background(), pubsub.Delivery{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := h(ctx, pubsub.Delivery{}); got != pubsub.NackRequeue {
+		t.Errorf("got %v for a canceled wait, want requeue", got)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	h := pubsub.Recover(func(context.Context, pubsub.Delivery) pubsub.AckType {
+		var units map[int]string
+		units[1] = "infantry"
+		return pubsub.Ack
+	})
+	if got := h(context.Background(), pubsub.Delivery{}); got != pubsub.NackDiscard {
+		t.Errorf("got %v for a panicking handler, want discard", got)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	canceled := make(chan struct{})
+	finished := make(chan struct{})
+	h := pubsub.Timeout(10*time.Millisecond, pubsub.NackRetry)(func(ctx context.Context, d pubsub.Delivery) pubsub.AckType {
+		defer close(finished)
+		<-ctx.Done()
+		close(canceled)
+		<-release
+		return pubsub.Ack
+	})
+
+	start := time.Now()
+	if got := h(context.Background(), pubsub.Delivery{}); got != pubsub.NackRetry {
+		t.Errorf("got %v for a handler that timed out, want retry", got)
+	}
+	if elapsed := time.Since(start); elapsed > testTimeout {
+		t.Errorf("Timeout waited %v for the handler", elapsed)
+	}
+	<-canceled
+
+	// The handler is left running in the background until it gives up.
+	select {
+	case <-finished:
+		t.Fatal("handler finished before it was released")
+	default:
+	}
+	close(release)
+	select {
+	case <-finished:
+	case <-time.After(testTimeout):
+		t.Fatal("handler never finished")
+	}
+
+	fast := pubsub.Timeout(testTimeout, pubsub.NackRetry)(func(context.Context, pubsub.Delivery) pubsub.AckType {
+		return pubsub.NackDiscard
+	})
+	if got := fast(context.Background(), pubsub.Delivery{}); got != pubsub.NackDiscard {
+		t.Errorf("got %v for a handler that finished in time, want discard", got)
+	}
+
+	// The handler's goroutine recovers its own panics.
+	panicky := pubsub.Timeout(testTimeout, pubsub.NackRetry)(func(context.Context, pubsub.Delivery) pubsub.AckType {
+		panic("malformed move")
+	})
+	if got := panicky(context.Background(), pubsub.Delivery{}); got != pubsub.NackDiscard {
+		t.Errorf("got %v for a panicking handler, want discard", got)
+	}
+}
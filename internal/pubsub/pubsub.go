@@ -1,68 +1,24 @@
 package pubsub
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
-	"encoding/json"
 	"fmt"
-
-	amqp "github.com/rabbitmq/amqp091-go"
+	"time"
 )
 
-func PublishJSON[T any](ch *amqp.Channel, exchange, routingKey string, msg T) error {
-	body, err := json.Marshal(msg)
+// Publish encodes msg with codec and publishes it. Publish with the context
+// of a Subscribe handler to have an EnvelopePublisher link the message to the
+// delivery being handled.
+func Publish[T any](ctx context.Context, pub Publisher, codec Codec, exchange, routingKey string, msg T) error {
+	body, err := codec.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("error marshalling message: %v", err)
 	}
 
-	err = ch.PublishWithContext(
-		context.Background(),
-		exchange,   // exchange
-		routingKey, // routing key
-		false,      // mandatory
-		false,      // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		})
-
-	if err != nil {
-		return fmt.Errorf("error publishing message: %v", err)
-	}
-	return nil
-}
-
-func DeclareAndBind(conn *amqp.Connection, exchange, queueName, bindingKey string, simpleQueueType QueueType) (*amqp.Channel, amqp.Queue, error) {
-	ch, err := conn.Channel()
-	if err != nil {
-		return nil, amqp.Queue{}, fmt.Errorf("error creating channel: %v", err)
-	}
-
-	q, err := ch.QueueDeclare(
-		queueName,                         // name
-		simpleQueueType == DurableQueue,   // durable
-		simpleQueueType == TransientQueue, // delete when unused
-		simpleQueueType == TransientQueue, // exclusive
-		false,                             // no-wait
-		amqp.Table{"x-dead-letter-exchange": "peril_dlx"},
-	)
-	if err != nil {
-		return nil, amqp.Queue{}, fmt.Errorf("error declaring queue: %v", err)
-	}
-
-	err = ch.QueueBind(
-		q.Name,     // queue name
-		bindingKey, // routing key
-		exchange,   // exchange
-		false,
-		nil,
-	)
-	if err != nil {
-		return nil, amqp.Queue{}, fmt.Errorf("error binding queue: %v", err)
-	}
-
-	return ch, q, nil
+	return pub.Publish(ctx, exchange, routingKey, Publishing{
+		ContentType: codec.ContentType(),
+		Body:        body,
+	})
 }
 
 type QueueType int
@@ -78,136 +34,82 @@ const (
 	Ack AckType = iota
 	NackRequeue
 	NackDiscard
+	// NackRetry redelivers the message after a delay when the subscription
+	// has a RetryPolicy, and requeues it straight away otherwise.
+	NackRetry
+	// NackPark moves the message to the parking queue when the subscription
+	// has a RetryPolicy, and discards it otherwise.
+	NackPark
 )
 
-func SubscribeJSON[T any](conn *amqp.Connection, exchange, queueName, bindingKey string, simpleQueueType QueueType, handler func(T) AckType) error {
-	ch, err := conn.Channel()
-	if err != nil {
-		return fmt.Errorf("error creating channel: %v", err)
-	}
+type subscribeOptions struct {
+	prefetch    int
+	retry       *RetryPolicy
+	dedup       *Deduplicator
+	middlewares []Middleware
 
-	_, q, err := DeclareAndBind(conn, exchange, queueName, bindingKey, simpleQueueType)
-	if err != nil {
-		return fmt.Errorf("error declaring and binding queue: %v", err)
-	}
-
-	msgs, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer
-		false,  // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
-	)
-	if err != nil {
-		return fmt.Errorf("error consuming: %v", err)
-	}
-
-	go func() {
-		for d := range msgs {
-			var msg T
-			err := json.Unmarshal(d.Body, &msg)
-			if err != nil {
-				fmt.Printf("Error unmarshalling message: %v\n", err)
-				continue
-			}
-			ackType := handler(msg)
-			switch ackType {
-			case Ack:
-				println("Acking message")
-				d.Ack(false)
-			case NackRequeue:
-				println("Nacking message and requeueing")
-				d.Nack(false, true)
-			case NackDiscard:
-				println("Nacking message and discarding")
-				d.Nack(false, false)
-			}
-		}
-	}()
-
-	return nil
+	timeout       time.Duration
+	timeoutPolicy AckType
 }
 
-func PublishGob[T any](ch *amqp.Channel, exchange, routingKey string, msg T) error {
-	var buffer bytes.Buffer
-	err := gob.NewEncoder(&buffer).Encode(msg)
-	if err != nil {
-		return fmt.Errorf("error marshalling message: %v", err)
-	}
-
-	err = ch.PublishWithContext(
-		context.Background(),
-		exchange,   // exchange
-		routingKey, // routing key
-		false,      // mandatory
-		false,      // immediate
-		amqp.Publishing{
-			ContentType: "application/gob",
-			Body:        buffer.Bytes(),
-		})
+type SubscribeOption func(*subscribeOptions)
 
-	if err != nil {
-		return fmt.Errorf("error publishing message: %v", err)
+// WithPrefetch limits how many unacknowledged messages the broker hands the
+// subscription at once.
+func WithPrefetch(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.prefetch = n
 	}
-	return nil
 }
 
-func SubscribeGob[T any](conn *amqp.Connection, exchange, queueName, bindingKey string, simpleQueueType QueueType, handler func(T) AckType) error {
-	ch, err := conn.Channel()
-	if err != nil {
-		return fmt.Errorf("error creating channel: %v", err)
+// Subscribe declares and binds the queue and hands every message to handler,
+// decoding it with whichever registered codec matches its content type.
+// Messages that can't be decoded are parked. The handler's context carries
+// the message's metadata too, see MetadataFrom.
+//
+// Around the handler run, outermost first: retries, deduplication, LogAcks,
+// Recover, the timeout if any, then the middlewares added with
+// WithMiddleware.
+func Subscribe[T any](sub Subscriber, exchange, queueName, bindingKey string, simpleQueueType QueueType, handler func(context.Context, Message[T]) AckType, opts ...SubscribeOption) (*Subscription, error) {
+	options := subscribeOptions{}
+	for _, opt := range opts {
+		opt(&options)
 	}
 
-	_, q, err := DeclareAndBind(conn, exchange, queueName, bindingKey, simpleQueueType)
+	err := sub.DeclareAndBind(exchange, queueName, bindingKey, simpleQueueType)
 	if err != nil {
-		return fmt.Errorf("error declaring and binding queue: %v", err)
+		return nil, fmt.Errorf("error declaring and binding queue: %v", err)
 	}
 
-	ch.Qos(10, 0, false)
-
-	msgs, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer
-		false,  // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
-	)
-	if err != nil {
-		return fmt.Errorf("error consuming: %v", err)
+	var retry *retrier
+	if options.retry != nil {
+		retry, err = newRetrier(sub, queueName, simpleQueueType, *options.retry)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up retries: %v", err)
+		}
 	}
 
-	go func() {
-		for d := range msgs {
-			if d.ContentType != "application/gob" {
-				fmt.Printf("Error: content type is not application/gob: %v\n", d.ContentType)
-				d.Nack(false, false)
-				continue
-			}
-			var msg T
-			err := gob.NewDecoder(bytes.NewReader(d.Body)).Decode(&msg)
-			if err != nil {
-				fmt.Printf("Error unmarshalling message: %v\n", err)
-				d.Nack(false, false)
-				continue
-			}
-			ackType := handler(msg)
-			switch ackType {
-			case Ack:
-				println("Acking message")
-				d.Ack(false)
-			case NackRequeue:
-				println("Nacking message and requeueing")
-				d.Nack(false, true)
-			case NackDiscard:
-				println("Nacking message and discarding")
-				d.Nack(false, false)
-			}
+	mws := []Middleware{retry.middleware, Dedup(options.dedup, queueName), LogAcks, Recover}
+	if options.timeout > 0 {
+		mws = append(mws, Timeout(options.timeout, options.timeoutPolicy))
+	}
+	mws = append(mws, options.middlewares...)
+	h := Chain(func(ctx context.Context, d Delivery) AckType {
+		codec, ok := CodecFor(d.ContentType)
+		if !ok {
+			fmt.Printf("Error: unsupported content type: %v\n", d.ContentType)
+			return NackPark
+		}
+		msg := Message[T]{Delivery: d}
+		err := codec.Unmarshal(d.Body, &msg.Msg)
+		if err != nil {
+			fmt.Printf("Error unmarshalling message: %v\n", err)
+			return NackPark
 		}
-	}()
+		return handler(ctx, msg)
+	}, mws...)
 
-	return nil
+	return sub.Consume(queueName, options.prefetch, func(d Delivery) AckType {
+		return h(ContextWithMetadata(context.Background(), d.Metadata), d)
+	})
 }
@@ -0,0 +1,274 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	RetryHeader              = "x-retry"
+	ParkReasonHeader         = "x-park-reason"
+	OriginalExchangeHeader   = "x-original-exchange"
+	OriginalRoutingKeyHeader = "x-original-routing-key"
+	OriginalUserIDHeader     = "x-original-user-id"
+)
+
+const (
+	defaultRetryMaxAttempts  = 5
+	defaultRetryInitialDelay = time.Second
+	defaultRetryMaxDelay     = time.Minute
+	defaultRetryMultiplier   = 2
+)
+
+// RetryPolicy controls what happens when a handler returns NackRetry. The
+// message is acked and republished to a delay queue whose TTL sends it back
+// to the subscription's queue, waiting longer after every attempt. Once it
+// has been handled MaxAttempts times it is parked instead.
+type RetryPolicy struct {
+	// MaxAttempts counts every delivery to the handler, including the first.
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	// ParkingQueue receives messages that ran out of attempts or were
+	// parked by the handler. Defaults to the queue name plus ".parking".
+	ParkingQueue string
+}
+
+// WithRetry enables delayed retries and parking for the subscription. The
+// subscriber must also be a Publisher.
+func WithRetry(policy RetryPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.retry = &policy
+	}
+}
+
+func (p RetryPolicy) withDefaults(queueName string) RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if p.InitialDelay <= 0 {
+		p.InitialDelay = defaultRetryInitialDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = defaultRetryMaxDelay
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = defaultRetryMultiplier
+	}
+	if p.ParkingQueue == "" {
+		p.ParkingQueue = queueName + ".parking"
+	}
+	return p
+}
+
+// delay is how long to wait before the given retry, counting from 1.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := float64(p.InitialDelay)
+	for i := 1; i < retry; i++ {
+		d *= p.Multiplier
+		if d >= float64(p.MaxDelay) {
+			return p.MaxDelay
+		}
+	}
+	return time.Duration(d)
+}
+
+type retrier struct {
+	pub       Publisher
+	policy    RetryPolicy
+	queueName string
+}
+
+// newRetrier declares the parking queue and one delay queue per distinct
+// delay the policy can produce. They last as long as the subscription's
+// queue: a transient queue's go away with the connection, like it does.
+func newRetrier(sub Subscriber, queueName string, simpleQueueType QueueType, policy RetryPolicy) (*retrier, error) {
+	pub, ok := sub.(Publisher)
+	if !ok {
+		return nil, fmt.Errorf("retry policy needs a subscriber that can publish, got %T", sub)
+	}
+	policy = policy.withDefaults(queueName)
+
+	durable := simpleQueueType == DurableQueue
+	err := sub.DeclareQueue(policy.ParkingQueue, QueueOptions{
+		Durable:   durable,
+		Exclusive: !durable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r := &retrier{
+		pub:       pub,
+		policy:    policy,
+		queueName: queueName,
+	}
+	declared := map[string]struct{}{}
+	for retry := 1; retry < policy.MaxAttempts; retry++ {
+		name := r.delayQueueName(retry)
+		if _, ok := declared[name]; ok {
+			continue
+		}
+		err := sub.DeclareQueue(name, QueueOptions{
+			Durable:              durable,
+			Exclusive:            !durable,
+			MessageTTL:           policy.delay(retry),
+			DeadLetterRoutingKey: queueName,
+		})
+		if err != nil {
+			return nil, err
+		}
+		declared[name] = struct{}{}
+	}
+	return r, nil
+}
+
+func (r *retrier) delayQueueName(retry int) string {
+	return fmt.Sprintf("%s.retry.%d", r.queueName, r.policy.delay(retry).Milliseconds())
+}
+
+// middleware settles whatever the rest of the chain returned.
+func (r *retrier) middleware(next Handler) Handler {
+	return func(ctx context.Context, d Delivery) AckType {
+		return r.settle(d, next(ctx, d))
+	}
+}
+
+// settle turns NackRetry and NackPark into what the broker should do with
+// the delivery. Without a retry policy they fall back to requeueing and
+// discarding.
+func (r *retrier) settle(d Delivery, ackType AckType) AckType {
+	if r == nil {
+		return settleWithoutRetry(ackType)
+	}
+	switch ackType {
+	case NackRetry:
+		return r.retry(d)
+	case NackPark:
+		return r.park(d, "parked by handler")
+	}
+	return ackType
+}
+
+// settleWithoutRetry is what NackRetry and NackPark come down to without a
+// retry policy. Consumers settle whatever their handler returned with it.
+func settleWithoutRetry(ackType AckType) AckType {
+	switch ackType {
+	case NackRetry:
+		return NackRequeue
+	case NackPark:
+		return NackDiscard
+	}
+	return ackType
+}
+
+func (r *retrier) retry(d Delivery) AckType {
+	retry := retryCount(d.Headers, r.queueName) + 1
+	if retry >= r.policy.MaxAttempts {
+		return r.park(d, fmt.Sprintf("gave up after %d attempts", retry))
+	}
+
+	headers := originalHeaders(d)
+	headers[RetryHeader] = retry
+	err := r.pub.Publish(context.Background(), "", r.delayQueueName(retry), forward(d, headers))
+	if err != nil {
+		fmt.Printf("Error scheduling retry: %v\n", err)
+		return NackRequeue
+	}
+	return Ack
+}
+
+func (r *retrier) park(d Delivery, reason string) AckType {
+	headers := originalHeaders(d)
+	headers[ParkReasonHeader] = reason
+	err := r.pub.Publish(context.Background(), "", r.policy.ParkingQueue, forward(d, headers))
+	if err != nil {
+		fmt.Printf("Error parking message: %v\n", err)
+		return NackRequeue
+	}
+	return Ack
+}
+
+// originalHeaders copies the delivery's headers and remembers where it was
+// first published, since the trip through a delay queue rewrites both.
+func originalHeaders(d Delivery) map[string]any {
+	headers := maps.Clone(d.Headers)
+	if headers == nil {
+		headers = map[string]any{}
+	}
+	if _, ok := headers[OriginalExchangeHeader]; !ok {
+		headers[OriginalExchangeHeader] = d.Exchange
+		headers[OriginalRoutingKeyHeader] = d.RoutingKey
+	}
+	if _, ok := headers[OriginalUserIDHeader]; !ok && d.Metadata.UserID != "" {
+		headers[OriginalUserIDHeader] = d.Metadata.UserID
+	}
+	return headers
+}
+
+// forward republishes d with headers. The broker only lets the sender's own
+// login set the user ID, so it travels on in OriginalUserIDHeader instead.
+func forward(d Delivery, headers map[string]any) Publishing {
+	m := d.Metadata
+	m.UserID = ""
+	return Publishing{
+		Metadata:    m,
+		ContentType: d.ContentType,
+		Headers:     headers,
+		Body:        d.Body,
+	}
+}
+
+// retryCount reads how often a message has been retried from the x-retry
+// header, falling back to the x-death records of the queue's delay queues.
+func retryCount(headers map[string]any, queueName string) int {
+	if n, ok := toInt(headers[RetryHeader]); ok {
+		return n
+	}
+	deaths, _ := headers["x-death"].([]any)
+	total := 0
+	for _, death := range deaths {
+		var entry map[string]any
+		switch e := death.(type) {
+		case amqp.Table:
+			entry = e
+		case map[string]any:
+			entry = e
+		default:
+			continue
+		}
+		queue, _ := entry["queue"].(string)
+		if entry["reason"] != "expired" || !strings.HasPrefix(queue, queueName+".retry.") {
+			continue
+		}
+		n, _ := toInt(entry["count"])
+		total += n
+	}
+	return total
+}
+
+func toInt(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int8:
+		return int(n), true
+	case int16:
+		return int(n), true
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case string:
+		// STOMP headers are always strings
+		i, err := strconv.Atoi(n)
+		return i, err == nil
+	}
+	return 0, false
+}
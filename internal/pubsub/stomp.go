@@ -0,0 +1,594 @@
+package pubsub
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/stomp"
+)
+
+const stompRequestTimeout = 10 * time.Second
+
+// Headers that belong to STOMP or RabbitMQ's STOMP adapter rather than to the
+// message, and are left out of Delivery.Headers.
+var stompFrameHeaders = map[string]bool{
+	"destination":  true,
+	"message-id":   true,
+	"subscription": true,
+	"ack":          true,
+	"content-type": true,
+	"redelivered":  true,
+	"persistent":   true,
+	"receipt":      true,
+	// metadata, see stompMetadataHeaders
+	"amqp-message-id": true,
+	"timestamp":       true,
+	"app-id":          true,
+	"user-id":         true,
+	"correlation-id":  true,
+}
+
+// STOMPBroker talks to RabbitMQ's STOMP plugin. Publishing to an exchange
+// goes to /exchange/<exchange>/<routing key>, publishing to a queue through
+// the default exchange goes to /queue/<name>. STOMP cannot declare exchanges
+// or bind queues on its own, so declarations are remembered and only take
+// effect when a queue is consumed: every binding of the queue becomes a
+// subscription to its exchange destination, with the queue's name and
+// arguments in the x-queue-name and x- headers RabbitMQ understands.
+// Exchanges have to exist already, e.g. from `server topology apply`.
+//
+// Unlike AMQPBroker it does not reconnect.
+type STOMPBroker struct {
+	conn net.Conn
+	done chan struct{}
+
+	writeMu sync.Mutex
+	w       *bufio.Writer
+
+	mu        sync.Mutex
+	closing   bool
+	closed    bool
+	err       error
+	nextID    uint64
+	queues    map[string]QueueOptions
+	transient map[string]bool
+	bindings  map[string][]stompBinding
+	receipts  map[string]chan stomp.Frame
+	consumers map[string]*stompConsumer
+	listeners []chan ConnectionState
+}
+
+type stompBinding struct {
+	exchange   string
+	bindingKey string
+}
+
+type stompConsumer struct {
+	broker  *STOMPBroker
+	ids     []string
+	handler func(Delivery) AckType
+	sub     *Subscription
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  []stomp.Frame
+	stopped  bool
+	connLost bool
+}
+
+func DialSTOMP(addr, login, passcode string) (*STOMPBroker, error) {
+	conn, err := net.DialTimeout("tcp", addr, stompRequestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to STOMP: %v", err)
+	}
+	b := &STOMPBroker{
+		conn:      conn,
+		done:      make(chan struct{}),
+		w:         bufio.NewWriter(conn),
+		queues:    map[string]QueueOptions{},
+		transient: map[string]bool{},
+		bindings:  map[string][]stompBinding{},
+		receipts:  map[string]chan stomp.Frame{},
+		consumers: map[string]*stompConsumer{},
+	}
+
+	r := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(stompRequestTimeout))
+	err = stomp.Write(b.w, stomp.NewFrame(stomp.CommandConnect,
+		"accept-version", "1.2",
+		"host", "/",
+		"login", login,
+		"passcode", passcode,
+		"heart-beat", "0,0",
+	))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error connecting to STOMP: %v", err)
+	}
+	f, err := stomp.Read(r)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error connecting to STOMP: %v", err)
+	}
+	if f.Command != stomp.CommandConnected {
+		conn.Close()
+		return nil, fmt.Errorf("error connecting to STOMP: %v", stompError(f))
+	}
+	conn.SetDeadline(time.Time{})
+
+	go b.read(r)
+	return b, nil
+}
+
+// NotifyState registers a listener for connection state changes and returns
+// it. A STOMPBroker only ever reports losing its connection.
+func (b *STOMPBroker) NotifyState(c chan ConnectionState) chan ConnectionState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, c)
+	return c
+}
+
+// Publish sends msg and waits for RabbitMQ's receipt.
+func (b *STOMPBroker) Publish(ctx context.Context, exchange, routingKey string, msg Publishing) error {
+	f := stomp.NewFrame(stomp.CommandSend,
+		"content-type", msg.ContentType,
+		"persistent", "true",
+	)
+	for name, value := range msg.Metadata.setHeaders(maps.Clone(msg.Headers)) {
+		f.Headers[name] = fmt.Sprint(value)
+	}
+	stompMetadataHeaders(f, msg.Metadata)
+	f.Body = msg.Body
+	if exchange != "" {
+		f.Headers["destination"] = stomp.ExchangeDestination(exchange, routingKey)
+	} else {
+		b.mu.Lock()
+		opts, ok := b.queues[routingKey]
+		b.mu.Unlock()
+		f.Headers["destination"] = stomp.ExistingQueueDestination(routingKey)
+		if ok {
+			// /queue declares the queue if it is not there yet.
+			f.Headers["destination"] = stomp.QueueDestination(routingKey)
+			queueHeaders(f, opts)
+		}
+	}
+
+	err := b.request(ctx, f)
+	if err != nil {
+		return fmt.Errorf("error publishing message: %v", err)
+	}
+	return nil
+}
+
+// DeclareExchange only checks the connection; see STOMPBroker.
+func (b *STOMPBroker) DeclareExchange(name string, kind ExchangeKind) error {
+	return b.check()
+}
+
+func (b *STOMPBroker) DeclareQueue(name string, opts QueueOptions) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.checkLocked(); err != nil {
+		return err
+	}
+	b.queues[name] = opts
+	return nil
+}
+
+func (b *STOMPBroker) DeclareAndBind(exchange, queueName, bindingKey string, simpleQueueType QueueType) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.checkLocked(); err != nil {
+		return err
+	}
+	b.transient[queueName] = simpleQueueType == TransientQueue
+	b.addBinding(queueName, stompBinding{exchange: exchange, bindingKey: bindingKey})
+	return nil
+}
+
+func (b *STOMPBroker) BindQueue(queueName, exchange, bindingKey string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.checkLocked(); err != nil {
+		return err
+	}
+	b.addBinding(queueName, stompBinding{exchange: exchange, bindingKey: bindingKey})
+	return nil
+}
+
+// addBinding remembers a binding once. b.mu must be held.
+func (b *STOMPBroker) addBinding(queueName string, binding stompBinding) {
+	for _, existing := range b.bindings[queueName] {
+		if existing == binding {
+			return
+		}
+	}
+	b.bindings[queueName] = append(b.bindings[queueName], binding)
+}
+
+func (b *STOMPBroker) Consume(queueName string, prefetch int, handler func(Delivery) AckType) (*Subscription, error) {
+	b.mu.Lock()
+	if err := b.checkLocked(); err != nil {
+		b.mu.Unlock()
+		return nil, err
+	}
+	frames := b.subscribeFrames(queueName)
+	c := &stompConsumer{
+		broker:  b,
+		handler: handler,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	for _, f := range frames {
+		b.nextID++
+		id := "sub-" + strconv.FormatUint(b.nextID, 10)
+		f.Headers["id"] = id
+		f.Headers["ack"] = "client-individual"
+		if prefetch > 0 {
+			f.Headers["prefetch-count"] = strconv.Itoa(prefetch)
+		}
+		c.ids = append(c.ids, id)
+		b.consumers[id] = c
+	}
+	b.mu.Unlock()
+
+	c.sub = newSubscription(c.stop)
+	go c.run()
+
+	ctx, cancel := context.WithTimeout(context.Background(), stompRequestTimeout)
+	defer cancel()
+	for _, f := range frames {
+		err := b.request(ctx, f)
+		if err != nil {
+			c.stop()
+			return nil, fmt.Errorf("error consuming: %v", err)
+		}
+	}
+	return c.sub, nil
+}
+
+// subscribeFrames are the SUBSCRIBE frames that consume queueName, without
+// ids. b.mu must be held.
+func (b *STOMPBroker) subscribeFrames(queueName string) []stomp.Frame {
+	bindings := b.bindings[queueName]
+	opts, declared := b.queues[queueName]
+	if len(bindings) == 0 {
+		if !declared {
+			return []stomp.Frame{stomp.NewFrame(stomp.CommandSubscribe, "destination", stomp.ExistingQueueDestination(queueName))}
+		}
+		f := stomp.NewFrame(stomp.CommandSubscribe, "destination", stomp.QueueDestination(queueName))
+		queueHeaders(f, opts)
+		return []stomp.Frame{f}
+	}
+
+	frames := []stomp.Frame{}
+	for _, binding := range bindings {
+		f := stomp.NewFrame(stomp.CommandSubscribe,
+			"destination", stomp.ExchangeDestination(binding.exchange, binding.bindingKey),
+			"x-queue-name", queueName,
+		)
+		if declared {
+			queueHeaders(f, opts)
+		} else {
+			// declared by DeclareAndBind, like declareAndBind does over AMQP
+			transient := b.transient[queueName]
+			f.Headers["durable"] = strconv.FormatBool(!transient)
+			f.Headers["auto-delete"] = strconv.FormatBool(transient)
+			f.Headers["exclusive"] = strconv.FormatBool(transient)
+			f.Headers["x-dead-letter-exchange"] = DeadLetterExchange
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+// queueHeaders adds the headers that make RabbitMQ declare a queue the way
+// declareQueue does over AMQP.
+func queueHeaders(f stomp.Frame, opts QueueOptions) {
+	f.Headers["durable"] = strconv.FormatBool(opts.Durable)
+	f.Headers["auto-delete"] = "false"
+	f.Headers["exclusive"] = strconv.FormatBool(opts.Exclusive)
+	if opts.MessageTTL > 0 {
+		f.Headers["x-message-ttl"] = strconv.FormatInt(opts.MessageTTL.Milliseconds(), 10)
+	}
+	if opts.DeadLetterExchange != "" || opts.DeadLetterRoutingKey != "" {
+		f.Headers["x-dead-letter-exchange"] = opts.DeadLetterExchange
+	}
+	if opts.DeadLetterRoutingKey != "" {
+		f.Headers["x-dead-letter-routing-key"] = opts.DeadLetterRoutingKey
+	}
+}
+
+func (b *STOMPBroker) Close() error {
+	b.mu.Lock()
+	if b.closing {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closing = true
+	lost := b.err != nil
+	b.mu.Unlock()
+
+	if !lost {
+		ctx, cancel := context.WithTimeout(context.Background(), stompRequestTimeout)
+		defer cancel()
+		// The receipt means RabbitMQ has seen every frame sent before it.
+		b.request(ctx, stomp.NewFrame(stomp.CommandDisconnect))
+	}
+
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.fail(ErrClosed)
+	return nil
+}
+
+func (b *STOMPBroker) check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.checkLocked()
+}
+
+// checkLocked fails once the connection is gone. b.mu must be held.
+func (b *STOMPBroker) checkLocked() error {
+	if b.closed {
+		return ErrClosed
+	}
+	if b.err != nil {
+		return ErrNotConnected
+	}
+	return nil
+}
+
+func (b *STOMPBroker) send(f stomp.Frame) error {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+	b.conn.SetWriteDeadline(time.Now().Add(stompRequestTimeout))
+	return stomp.Write(b.w, f)
+}
+
+// request sends f with a receipt header and waits for the receipt.
+func (b *STOMPBroker) request(ctx context.Context, f stomp.Frame) error {
+	b.mu.Lock()
+	if err := b.checkLocked(); err != nil {
+		b.mu.Unlock()
+		return err
+	}
+	b.nextID++
+	id := "receipt-" + strconv.FormatUint(b.nextID, 10)
+	reply := make(chan stomp.Frame, 1)
+	b.receipts[id] = reply
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.receipts, id)
+		b.mu.Unlock()
+	}()
+
+	f.Headers["receipt"] = id
+	err := b.send(f)
+	if err != nil {
+		return err
+	}
+	select {
+	case r := <-reply:
+		if r.Command == stomp.CommandError {
+			return stompError(r)
+		}
+		return nil
+	case <-b.done:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// read dispatches incoming frames until the connection fails.
+func (b *STOMPBroker) read(r *bufio.Reader) {
+	for {
+		f, err := stomp.Read(r)
+		if err != nil {
+			b.fail(fmt.Errorf("lost connection to STOMP: %v", err))
+			return
+		}
+		switch f.Command {
+		case stomp.CommandMessage:
+			b.mu.Lock()
+			c := b.consumers[f.Headers["subscription"]]
+			b.mu.Unlock()
+			if c != nil {
+				c.push(f)
+			}
+		case stomp.CommandReceipt, stomp.CommandError:
+			b.mu.Lock()
+			reply, ok := b.receipts[f.Headers["receipt-id"]]
+			b.mu.Unlock()
+			if ok {
+				reply <- f
+			}
+			// RabbitMQ closes the connection after every ERROR.
+			if f.Command == stomp.CommandError {
+				b.fail(stompError(f))
+				return
+			}
+		}
+	}
+}
+
+// fail tears everything down after the connection was closed or lost.
+func (b *STOMPBroker) fail(err error) {
+	b.mu.Lock()
+	if b.err != nil {
+		b.mu.Unlock()
+		return
+	}
+	if b.closing {
+		err = ErrClosed
+	}
+	b.err = err
+	closing := b.closing
+	consumers := b.consumers
+	b.consumers = map[string]*stompConsumer{}
+	listeners := b.listeners
+	b.mu.Unlock()
+
+	b.conn.Close()
+	close(b.done)
+	for _, c := range consumers {
+		c.mu.Lock()
+		c.connLost = true
+		c.cond.Broadcast()
+		c.mu.Unlock()
+	}
+	if closing {
+		return
+	}
+	for _, l := range listeners {
+		select {
+		case l <- StateDisconnected:
+		default:
+		}
+	}
+}
+
+func (c *stompConsumer) push(f stomp.Frame) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		// Not for us anymore, give it back to the queue.
+		c.broker.send(stomp.NewFrame(stomp.CommandNack, "id", f.Headers["ack"]))
+		return
+	}
+	c.pending = append(c.pending, f)
+	c.cond.Signal()
+}
+
+func (c *stompConsumer) run() {
+	for {
+		c.mu.Lock()
+		for len(c.pending) == 0 && !c.stopped && !c.connLost {
+			c.cond.Wait()
+		}
+		switch {
+		case c.connLost:
+			c.mu.Unlock()
+			c.sub.finish(ErrClosed)
+			return
+		case c.stopped:
+			pending := c.pending
+			c.pending = nil
+			c.mu.Unlock()
+			for _, f := range pending {
+				c.broker.send(stomp.NewFrame(stomp.CommandNack, "id", f.Headers["ack"]))
+			}
+			c.broker.mu.Lock()
+			for _, id := range c.ids {
+				delete(c.broker.consumers, id)
+			}
+			c.broker.mu.Unlock()
+			c.sub.finish(nil)
+			return
+		}
+		f := c.pending[0]
+		c.pending = c.pending[1:]
+		c.mu.Unlock()
+
+		ackType := settleWithoutRetry(c.handler(stompDelivery(f)))
+		var err error
+		switch ackType {
+		case Ack:
+			err = c.broker.send(stomp.NewFrame(stomp.CommandAck, "id", f.Headers["ack"]))
+		case NackRequeue:
+			err = c.broker.send(stomp.NewFrame(stomp.CommandNack, "id", f.Headers["ack"]))
+		case NackDiscard:
+			err = c.broker.send(stomp.NewFrame(stomp.CommandNack, "id", f.Headers["ack"], "requeue", "false"))
+		}
+		if err != nil {
+			fmt.Printf("Error settling message: %v\n", err)
+		}
+	}
+}
+
+// stop unsubscribes. Messages already received but not handled yet are
+// handed back to RabbitMQ.
+func (c *stompConsumer) stop() error {
+	var errs []error
+	// Without a connection there is nothing to unsubscribe from.
+	if c.broker.check() == nil {
+		for _, id := range c.ids {
+			errs = append(errs, c.broker.send(stomp.NewFrame(stomp.CommandUnsubscribe, "id", id)))
+		}
+	}
+	c.mu.Lock()
+	c.stopped = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+func stompDelivery(f stomp.Frame) Delivery {
+	d := Delivery{
+		ContentType: f.Headers["content-type"],
+		Headers:     map[string]any{},
+		Body:        f.Body,
+		Redelivered: f.Headers["redelivered"] == "true",
+	}
+	dest, err := stomp.ParseDestination(f.Headers["destination"])
+	if err == nil {
+		d.Exchange, d.RoutingKey = dest.Exchange, dest.RoutingKey
+		if dest.Queue != "" {
+			d.RoutingKey = dest.Queue
+		}
+	}
+	for name, value := range f.Headers {
+		if !stompFrameHeaders[name] {
+			d.Headers[name] = value
+		}
+	}
+	d.Metadata = Metadata{
+		MessageID:     f.Headers["amqp-message-id"],
+		Timestamp:     parseUnix(f.Headers["timestamp"]),
+		AppID:         f.Headers["app-id"],
+		UserID:        f.Headers["user-id"],
+		CorrelationID: f.Headers["correlation-id"],
+	}
+	d.Metadata.takeHeaders(d.Headers)
+	return d
+}
+
+// stompMetadataHeaders adds the headers RabbitMQ's STOMP plugin maps to AMQP
+// properties. message-id is taken by STOMP itself.
+func stompMetadataHeaders(f stomp.Frame, m Metadata) {
+	if m.MessageID != "" {
+		f.Headers["amqp-message-id"] = m.MessageID
+	}
+	if !m.Timestamp.IsZero() {
+		f.Headers["timestamp"] = unixString(m.Timestamp)
+	}
+	if m.AppID != "" {
+		f.Headers["app-id"] = m.AppID
+	}
+	if m.UserID != "" {
+		f.Headers["user-id"] = m.UserID
+	}
+	if m.CorrelationID != "" {
+		f.Headers["correlation-id"] = m.CorrelationID
+	}
+}
+
+func stompError(f stomp.Frame) error {
+	msg := f.Headers["message"]
+	if len(f.Body) > 0 {
+		msg += ": " + string(f.Body)
+	}
+	return fmt.Errorf("STOMP error: %s", msg)
+}
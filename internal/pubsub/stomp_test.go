@@ -0,0 +1,316 @@
+package pubsub_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/stomp/stomptest"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topology"
+)
+
+const testTimeout = 5 * time.Second
+
+// startSTOMP runs a fake STOMP server with the Peril topology applied and
+// connects to it. The returned MemoryConn talks to the server's broker
+// directly, to look at queues behind the STOMP client's back.
+func startSTOMP(t *testing.T) (*pubsub.STOMPBroker, *pubsub.MemoryConn) {
+	t.Helper()
+	srv, err := stomptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	conn := srv.Broker().Connect()
+	t.Cleanup(func() { conn.Close() })
+	err = topology.Peril().Apply(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker, err := pubsub.DialSTOMP(srv.Addr(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { broker.Close() })
+	return broker, conn
+}
+
+func closeOnCleanup(t *testing.T, sub *pubsub.Subscription) {
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		sub.Close(ctx)
+	})
+}
+
+func receive[T any](t *testing.T, c <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-c:
+		return v
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for a message")
+	}
+	var zero T
+	return zero
+}
+
+func expectNothing[T any](t *testing.T, c <-chan T) {
+	t.Helper()
+	select {
+	case v := <-c:
+		t.Fatalf("got unexpected %+v", v)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSTOMPPublishSubscribe(t *testing.T) {
+	broker, _ := startSTOMP(t)
+
+	pauses := make(chan routing.PlayingState, 1)
+	sub, err := pubsub.Subscribe(broker, routing.ExchangePerilDirect, "pause.test", routing.PauseKey, pubsub.TransientQueue, func(_ context.Context, d pubsub.Message[routing.PlayingState]) pubsub.AckType {
+		pauses <- d.Msg
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+
+	deltas := make(chan pubsub.Message[gamelogic.StateDelta], 2)
+	sub, err = pubsub.Subscribe(broker, routing.ExchangePerilTopic, routing.WorldDeltasPrefix+".test", routing.WorldDeltasPrefix+".*", pubsub.TransientQueue, func(_ context.Context, d pubsub.Message[gamelogic.StateDelta]) pubsub.AckType {
+		deltas <- d
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+
+	ctx := context.Background()
+	err = pubsub.Publish(ctx, broker, pubsub.JSON, routing.ExchangePerilDirect, routing.PauseKey, routing.PlayingState{IsPaused: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps := receive(t, pauses); !ps.IsPaused {
+		t.Errorf("got %+v, want paused", ps)
+	}
+
+	err = pubsub.Publish(ctx, broker, pubsub.JSON, routing.ExchangePerilTopic, routing.WorldDeltasPrefix+".alice", gamelogic.StateDelta{Seq: 7, Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := receive(t, deltas)
+	if d.Msg.Seq != 7 || d.Msg.Username != "alice" {
+		t.Errorf("got %+v, want seq 7 for alice", d.Msg)
+	}
+	if d.Exchange != routing.ExchangePerilTopic || d.RoutingKey != routing.WorldDeltasPrefix+".alice" {
+		t.Errorf("delivered from %s/%s", d.Exchange, d.RoutingKey)
+	}
+
+	// Not matched by world_deltas.*.
+	err = pubsub.Publish(ctx, broker, pubsub.JSON, routing.ExchangePerilTopic, routing.WorldDeltasPrefix+".alice.extra", gamelogic.StateDelta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectNothing(t, deltas)
+}
+
+func TestSTOMPAck(t *testing.T) {
+	broker, conn := startSTOMP(t)
+
+	got := make(chan routing.GameLog, 2)
+	sub, err := pubsub.Subscribe(broker, routing.ExchangePerilTopic, routing.GameLogSlug, routing.GameLogSlug+".*", pubsub.DurableQueue, func(_ context.Context, d pubsub.Message[routing.GameLog]) pubsub.AckType {
+		got <- d.Msg
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pubsub.Publish(context.Background(), broker, pubsub.JSON, routing.ExchangePerilTopic, routing.GameLogSlug+".alice", routing.GameLog{Username: "alice", Message: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log := receive(t, got); log.Message != "hi" {
+		t.Errorf("got %+v", log)
+	}
+	err = sub.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An acked message is gone from the durable queue for good.
+	left := make(chan pubsub.Delivery, 1)
+	sub, err = conn.Consume(routing.GameLogSlug, 0, func(d pubsub.Delivery) pubsub.AckType {
+		left <- d
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+	expectNothing(t, left)
+}
+
+func TestSTOMPNackRequeue(t *testing.T) {
+	broker, _ := startSTOMP(t)
+
+	got := make(chan pubsub.Message[routing.GameLog], 2)
+	sub, err := pubsub.Subscribe(broker, routing.ExchangePerilTopic, routing.GameLogSlug, routing.GameLogSlug+".*", pubsub.DurableQueue, func(_ context.Context, d pubsub.Message[routing.GameLog]) pubsub.AckType {
+		got <- d
+		if !d.Redelivered {
+			return pubsub.NackRequeue
+		}
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+
+	err = pubsub.Publish(context.Background(), broker, pubsub.JSON, routing.ExchangePerilTopic, routing.GameLogSlug+".alice", routing.GameLog{Username: "alice", Message: "again"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := receive(t, got)
+	second := receive(t, got)
+	if first.Redelivered || !second.Redelivered {
+		t.Errorf("redelivered: first %v, second %v", first.Redelivered, second.Redelivered)
+	}
+	if second.Msg.Message != "again" {
+		t.Errorf("got %+v after requeueing", second.Msg)
+	}
+	expectNothing(t, got)
+}
+
+func TestSTOMPNackDiscard(t *testing.T) {
+	broker, conn := startSTOMP(t)
+
+	dead := make(chan pubsub.Delivery, 1)
+	sub, err := conn.Consume(routing.QueuePerilDLQ, 0, func(d pubsub.Delivery) pubsub.AckType {
+		dead <- d
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+
+	got := make(chan routing.GameLog, 2)
+	sub, err = pubsub.Subscribe(broker, routing.ExchangePerilTopic, routing.GameLogSlug, routing.GameLogSlug+".*", pubsub.DurableQueue, func(_ context.Context, d pubsub.Message[routing.GameLog]) pubsub.AckType {
+		got <- d.Msg
+		return pubsub.NackDiscard
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+
+	err = pubsub.Publish(context.Background(), broker, pubsub.JSON, routing.ExchangePerilTopic, routing.GameLogSlug+".alice", routing.GameLog{Username: "alice", Message: "bad"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, got)
+	expectNothing(t, got)
+
+	d := receive(t, dead)
+	deaths, _ := d.Headers["x-death"].([]any)
+	if len(deaths) != 1 {
+		t.Fatalf("got x-death %v, want one entry", d.Headers["x-death"])
+	}
+	death := deaths[0].(map[string]any)
+	if death["queue"] != routing.GameLogSlug || death["reason"] != "rejected" {
+		t.Errorf("got x-death %v", death)
+	}
+}
+
+func TestSTOMPUserID(t *testing.T) {
+	srv, err := stomptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	conn := srv.Broker().Connect()
+	t.Cleanup(func() { conn.Close() })
+	err = topology.Peril().Apply(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := pubsub.DialSTOMP(srv.Addr(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { watcher.Close() })
+
+	got := make(chan pubsub.Message[routing.GameLog], 2)
+	sub, err := pubsub.Subscribe(watcher, routing.ExchangePerilTopic, routing.GameLogSlug+".test", routing.GameLogSlug+".*", pubsub.TransientQueue, func(_ context.Context, d pubsub.Message[routing.GameLog]) pubsub.AckType {
+		got <- d
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+
+	// Like RabbitMQ, the server drops the connection of a client that
+	// publishes as somebody else, so every publish gets its own.
+	publish := func(userID string) error {
+		alice, err := pubsub.DialSTOMP(srv.Addr(), "alice", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer alice.Close()
+		return pubsub.Publish(context.Background(), pubsub.NewEnvelopePublisher(alice, "peril-client", userID), pubsub.JSON, routing.ExchangePerilTopic, routing.GameLogSlug+".alice", routing.GameLog{})
+	}
+	if err := publish("bob"); err == nil {
+		t.Error("alice published as bob")
+	}
+	expectNothing(t, got)
+	if err := publish("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if d := receive(t, got); d.Metadata.UserID != "alice" {
+		t.Errorf("got user ID %q, want alice", d.Metadata.UserID)
+	}
+}
+
+func TestSTOMPConsumeWithoutRetry(t *testing.T) {
+	broker, conn := startSTOMP(t)
+	dead := consume(t, conn, routing.QueuePerilDLQ, pubsub.Ack)
+	err := broker.DeclareAndBind(routing.ExchangePerilTopic, routing.GameLogSlug, routing.GameLogSlug+".*", pubsub.DurableQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a retry policy NackRetry requeues and NackPark discards.
+	got := make(chan pubsub.Delivery, 4)
+	sub, err := broker.Consume(routing.GameLogSlug, 0, func(d pubsub.Delivery) pubsub.AckType {
+		got <- d
+		if !d.Redelivered {
+			return pubsub.NackRetry
+		}
+		return pubsub.NackPark
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeOnCleanup(t, sub)
+
+	publishRaw(t, conn, routing.ExchangePerilTopic, routing.GameLogSlug+".alice", "again")
+	if d := receive(t, got); d.Redelivered {
+		t.Error("first delivery is redelivered")
+	}
+	if d := receive(t, got); !d.Redelivered {
+		t.Error("NackRetry didn't requeue")
+	}
+	if d := receive(t, dead); string(d.Body) != "again" {
+		t.Errorf("dead-lettered %q", d.Body)
+	}
+	expectNothing(t, got)
+}
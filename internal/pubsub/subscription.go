@@ -0,0 +1,101 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrClosed is reported by subscriptions whose broker connection was closed
+// underneath them.
+var ErrClosed = errors.New("broker connection closed")
+
+// Subscription is a handle on a running consumer.
+type Subscription struct {
+	stop func() error
+	done chan struct{}
+
+	mu      sync.Mutex
+	closing bool
+	once    sync.Once
+	err     error
+}
+
+func newSubscription(stop func() error) *Subscription {
+	return &Subscription{
+		stop: stop,
+		done: make(chan struct{}),
+	}
+}
+
+// Close cancels the consumer so no new messages are delivered and waits until
+// the handler has finished with every message already received, or until ctx
+// expires.
+func (s *Subscription) Close(ctx context.Context) error {
+	s.mu.Lock()
+	alreadyClosing := s.closing
+	s.closing = true
+	s.mu.Unlock()
+
+	if !alreadyClosing {
+		err := s.stop()
+		if err != nil {
+			return fmt.Errorf("error cancelling consumer: %v", err)
+		}
+	}
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed once the consumer has stopped for good and no handler is
+// running anymore.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err explains why the consumer stopped. It is nil while the consumer is
+// running and after a clean Close.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Subscription) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+func (s *Subscription) finish(err error) {
+	s.once.Do(func() {
+		s.mu.Lock()
+		if !s.closing {
+			s.err = err
+		}
+		s.mu.Unlock()
+		close(s.done)
+	})
+}
+
+// CloseAll closes every subscription, waiting for their handlers in
+// parallel, and returns the errors of those that did not finish in time.
+func CloseAll(ctx context.Context, subs ...*Subscription) error {
+	errs := make([]error, len(subs))
+	var wg sync.WaitGroup
+	for i, sub := range subs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = sub.Close(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
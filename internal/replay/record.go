@@ -0,0 +1,164 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// recordingVersion 2 added the sender of every record.
+const recordingVersion = 2
+
+// recorderQueue is the prefix of every recorder's queue. Each one gets a
+// name of its own, so servers sharing a broker don't fight over it.
+const recorderQueue = "replay_recorder"
+
+// Meta is the first line of a recording: everything besides the messages
+// that the server's decisions depend on.
+type Meta struct {
+	Version  int
+	Started  time.Time
+	Seed     int64
+	Combat   string
+	Map      []byte                   `json:",omitempty"`
+	Snapshot *gamelogic.WorldSnapshot `json:",omitempty"`
+}
+
+// Record is one message as it went through the broker.
+type Record struct {
+	Time        time.Time
+	Exchange    string
+	RoutingKey  string
+	ContentType string
+	UserID      string `json:",omitempty"`
+	Body        []byte
+}
+
+type Recording struct {
+	Meta    Meta
+	Records []Record
+}
+
+// Recorder writes every message on peril_direct and peril_topic to a file,
+// one JSON record per line.
+type Recorder struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+func Create(path string, meta Meta) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not create recording: %v", err)
+	}
+	r := &Recorder{
+		f: f,
+		w: bufio.NewWriter(f),
+	}
+	meta.Version = recordingVersion
+	err = r.write(meta)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Tap starts recording from a queue of its own. Messages that were already
+// waiting in other queues when it started are not recorded, so a replay is
+// only exact when the server starts with empty queues.
+func (r *Recorder) Tap(broker pubsub.Broker) (*pubsub.Subscription, error) {
+	queueName := recorderQueue + "." + pubsub.NewMessageID()
+	err := broker.DeclareAndBind(routing.ExchangePerilTopic, queueName, "#", pubsub.TransientQueue)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range []string{routing.PauseKey, routing.TurnKey} {
+		err = broker.BindQueue(queueName, routing.ExchangePerilDirect, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return broker.Consume(queueName, 0, func(d pubsub.Delivery) pubsub.AckType {
+		err := r.write(Record{
+			Time:        time.Now(),
+			Exchange:    d.Exchange,
+			RoutingKey:  d.RoutingKey,
+			ContentType: d.ContentType,
+			UserID:      d.Metadata.UserID,
+			Body:        d.Body,
+		})
+		if err != nil {
+			fmt.Println("Error recording message:", err)
+		}
+		return pubsub.Ack
+	})
+}
+
+func (r *Recorder) write(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("could not encode record: %v", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.w.Write(append(line, '\n'))
+	if err == nil {
+		err = r.w.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("could not write recording: %v", err)
+	}
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.w.Flush()
+	return errors.Join(err, r.f.Close())
+}
+
+func Load(path string) (Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Recording{}, fmt.Errorf("could not open recording: %v", err)
+	}
+	defer f.Close()
+
+	rec := Recording{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	if !scanner.Scan() {
+		return Recording{}, errors.New("recording is empty")
+	}
+	err = json.Unmarshal(scanner.Bytes(), &rec.Meta)
+	if err != nil {
+		return Recording{}, fmt.Errorf("could not decode recording: %v", err)
+	}
+	if rec.Meta.Version < 1 || rec.Meta.Version > recordingVersion {
+		return Recording{}, fmt.Errorf("unsupported recording version %d", rec.Meta.Version)
+	}
+	for scanner.Scan() {
+		record := Record{}
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			// the server was killed halfway through a line
+			break
+		}
+		rec.Records = append(rec.Records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return Recording{}, fmt.Errorf("could not read recording: %v", err)
+	}
+	return rec, nil
+}
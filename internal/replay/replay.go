@@ -0,0 +1,237 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// maxMissedTurns is how far ahead of the world a recorded turn may start.
+// A recording without a snapshot can begin a few turns into the game, but
+// not a thousand.
+const maxMissedTurns = 1000
+
+// Result is what a replay ends with. Wars are the wars the replayed world
+// fought, and Mismatches every recorded delta it did not reproduce exactly.
+type Result struct {
+	Clients    map[string]*gamelogic.GameState
+	Wars       []gamelogic.CombatReport
+	Mismatches []string
+}
+
+// Replay runs a recording through a fresh world, seeded like the recorded
+// one, and through a fresh GameState for every client that joined. The
+// world is fed the players' messages in the order they were recorded, and
+// ticks and turns whenever the recorded server announced one. The clients
+// get the recorded deltas, exactly like they did during the game.
+//
+// What the world and the clients print goes to out.
+//
+// Replaying changes the current map if the recording used its own.
+func Replay(rec Recording, out io.Writer) (Result, error) {
+	if len(rec.Meta.Map) > 0 {
+		m, err := gamelogic.ParseMap(rec.Meta.Map)
+		if err != nil {
+			return Result{}, err
+		}
+		gamelogic.SetMap(m)
+	}
+
+	world := gamelogic.NewWorld()
+	if rec.Meta.Snapshot != nil {
+		var err error
+		world, err = gamelogic.NewWorldFromSnapshot(*rec.Meta.Snapshot)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	world.SetOutput(out)
+	switch rec.Meta.Combat {
+	case "classic":
+		world.SetCombatResolver(gamelogic.ClassicResolver{})
+	case "dice", "":
+		world.SetCombatResolver(gamelogic.NewDiceResolver(rec.Meta.Seed))
+	default:
+		return Result{}, fmt.Errorf("unknown combat model: %s", rec.Meta.Combat)
+	}
+
+	result := Result{Clients: map[string]*gamelogic.GameState{}}
+	replayed := map[uint64]gamelogic.StateDelta{}
+	recorded := []gamelogic.StateDelta{}
+	// Rejected orders aren't sequenced, so they are matched up separately.
+	rejected := []gamelogic.StateDelta{}
+	keep := func(deltas ...gamelogic.StateDelta) {
+		for _, delta := range deltas {
+			if delta.Seq != 0 {
+				replayed[delta.Seq] = delta
+			}
+		}
+	}
+
+	for i, r := range rec.Records {
+		prefix, _, _ := strings.Cut(r.RoutingKey, ".")
+		var err error
+		switch {
+		case r.Exchange == routing.ExchangePerilDirect && r.RoutingKey == routing.PauseKey:
+			ps := routing.PlayingState{}
+			err = decode(r, &ps)
+			world.SetPaused(ps.IsPaused)
+			for _, gs := range result.Clients {
+				gs.HandlePause(ps)
+			}
+		case r.Exchange == routing.ExchangePerilDirect && r.RoutingKey == routing.TurnKey:
+			ts := routing.TurnStart{}
+			err = decode(r, &ts)
+			if err != nil {
+				break
+			}
+			turn := world.StartTurn()
+			if missed := ts.Turn - turn; missed > maxMissedTurns {
+				err = fmt.Errorf("turn %d starts %d turn(s) ahead of the replay", ts.Turn, missed)
+				break
+			}
+			for turn < ts.Turn {
+				turn = world.StartTurn()
+			}
+			if turn != ts.Turn {
+				result.Mismatches = append(result.Mismatches, fmt.Sprintf("turn %d started as turn %d", ts.Turn, turn))
+			}
+			for _, gs := range result.Clients {
+				gs.HandleTurnStart(ts)
+			}
+		case r.Exchange != routing.ExchangePerilTopic:
+		case prefix == routing.IntentsPrefix:
+			intent := gamelogic.Intent{}
+			err = decode(r, &intent)
+			if sentBy(rec.Meta, r, intent.Username, intent.Username) {
+				keep(world.HandleIntent(intent))
+			}
+		case prefix == routing.OrdersPrefix:
+			batch := gamelogic.OrderBatch{}
+			err = decode(r, &batch)
+			if !sentBy(rec.Meta, r, batch.Username, batch.Username) {
+				break
+			}
+			if err := world.SubmitOrders(batch); err != nil {
+				rejected = append(rejected, gamelogic.StateDelta{
+					Username: batch.Username,
+					Intent:   gamelogic.IntentTurn,
+					Turn:     batch.Turn,
+					Error:    err.Error(),
+				})
+			}
+		case prefix == routing.DiplomacyPrefix:
+			msg := gamelogic.DiplomacyMessage{}
+			err = decode(r, &msg)
+			if sentBy(rec.Meta, r, msg.To, msg.From) {
+				if delta, ok := world.HandleDiplomacy(msg); ok {
+					keep(delta)
+				}
+			}
+			// The recipient got it either way.
+			if gs, ok := result.Clients[msg.To]; ok {
+				gs.HandleDiplomacy(msg)
+			}
+		case prefix == routing.WorldDeltasPrefix:
+			delta := gamelogic.StateDelta{}
+			err = decode(r, &delta)
+			if delta.Username == "" && delta.Intent == gamelogic.IntentTick {
+				if tick, ok := world.Tick(); ok {
+					keep(tick)
+				}
+			}
+			if delta.Username == "" && delta.Intent == gamelogic.IntentTurn {
+				keep(world.ResolveTurn()...)
+			}
+			recorded = append(recorded, delta)
+
+			if _, ok := result.Clients[delta.Username]; !ok && delta.Intent == gamelogic.IntentJoin {
+				gs := gamelogic.NewGameState(delta.Username)
+				gs.SetOutput(out)
+				result.Clients[delta.Username] = gs
+			}
+			for _, gs := range result.Clients {
+				gs.HandleDelta(delta)
+			}
+		}
+		if err != nil {
+			return Result{}, fmt.Errorf("record %d: %v", i+1, err)
+		}
+	}
+
+	seqs := []uint64{}
+	for seq := range replayed {
+		seqs = append(seqs, seq)
+	}
+	slices.Sort(seqs)
+	for _, seq := range seqs {
+		result.Wars = append(result.Wars, replayed[seq].Wars...)
+	}
+	for _, delta := range recorded {
+		if delta.Seq == 0 {
+			if delta.Error == "" || delta.Intent != gamelogic.IntentTurn {
+				continue
+			}
+			i := slices.IndexFunc(rejected, func(got gamelogic.StateDelta) bool {
+				return sameDelta(got, delta)
+			})
+			if i < 0 {
+				result.Mismatches = append(result.Mismatches, fmt.Sprintf("orders from %q for turn %d were not rejected with %q", delta.Username, delta.Turn, delta.Error))
+				continue
+			}
+			rejected = slices.Delete(rejected, i, i+1)
+			continue
+		}
+		got, ok := replayed[delta.Seq]
+		if !ok {
+			result.Mismatches = append(result.Mismatches, fmt.Sprintf("delta %d (%s from %q) was not reproduced", delta.Seq, delta.Intent, delta.Username))
+			continue
+		}
+		if !sameDelta(got, delta) {
+			result.Mismatches = append(result.Mismatches, fmt.Sprintf("delta %d (%s from %q) came out differently", delta.Seq, delta.Intent, delta.Username))
+		}
+	}
+	for _, delta := range rejected {
+		result.Mismatches = append(result.Mismatches, fmt.Sprintf("orders from %q for turn %d were rejected with %q", delta.Username, delta.Turn, delta.Error))
+	}
+	return result, nil
+}
+
+// sentBy makes the same check the server makes on players' messages before
+// handling them, so the replay skips the ones it rejected. Recordings from
+// before version 2 don't know the sender.
+func sentBy(meta Meta, r Record, keyName, username string) bool {
+	_, suffix, _ := strings.Cut(r.RoutingKey, ".")
+	if suffix != keyName {
+		return false
+	}
+	return meta.Version < 2 || r.UserID == username
+}
+
+func decode(r Record, v any) error {
+	codec, ok := pubsub.CodecFor(r.ContentType)
+	if !ok {
+		return fmt.Errorf("unsupported content type: %s", r.ContentType)
+	}
+	return codec.Unmarshal(r.Body, v)
+}
+
+// sameDelta compares deltas the way they travel, so a nil slice and an empty
+// one are the same.
+func sameDelta(a, b gamelogic.StateDelta) bool {
+	normalize := func(d gamelogic.StateDelta) []byte {
+		data, _ := json.Marshal(d)
+		d = gamelogic.StateDelta{}
+		json.Unmarshal(data, &d)
+		data, _ = json.Marshal(d)
+		return data
+	}
+	return bytes.Equal(normalize(a), normalize(b))
+}
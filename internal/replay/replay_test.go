@@ -0,0 +1,102 @@
+package replay
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/topology"
+)
+
+func record(t *testing.T, exchange, key, userID string, msg any) Record {
+	t.Helper()
+	body, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Record{
+		Exchange:    exchange,
+		RoutingKey:  key,
+		ContentType: pubsub.JSON.ContentType(),
+		UserID:      userID,
+		Body:        body,
+	}
+}
+
+func TestTapTwice(t *testing.T) {
+	conn := pubsub.NewMemoryBroker().Connect()
+	t.Cleanup(func() { conn.Close() })
+	err := topology.Peril().Apply(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.jsonl", "b.jsonl"} {
+		recorder, err := Create(filepath.Join(t.TempDir(), name), Meta{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { recorder.Close() })
+		_, err = recorder.Tap(conn)
+		if err != nil {
+			t.Fatalf("error tapping for %s: %v", name, err)
+		}
+	}
+}
+
+func TestReplayTurns(t *testing.T) {
+	turn := func(n int) Record {
+		return record(t, routing.ExchangePerilDirect, routing.TurnKey, "", routing.TurnStart{Turn: n})
+	}
+	orders := func(n int) Record {
+		return record(t, routing.ExchangePerilTopic, routing.OrdersPrefix+".alice", "alice", gamelogic.OrderBatch{Username: "alice", Turn: n})
+	}
+	rejection := record(t, routing.ExchangePerilTopic, routing.WorldDeltasPrefix+".alice", "", gamelogic.StateDelta{
+		Username: "alice",
+		Intent:   gamelogic.IntentTurn,
+		Turn:     2,
+		Error:    "orders are for turn 2, but this is turn 3",
+	})
+
+	tests := []struct {
+		name       string
+		records    []Record
+		mismatches []string
+	}{
+		{"catching up", []Record{turn(3), orders(3)}, nil},
+		{"rejected like the recording", []Record{turn(3), orders(2), rejection}, nil},
+		{"rejected unlike the recording", []Record{turn(3), orders(2)}, []string{"were rejected"}},
+		{"accepted unlike the recording", []Record{turn(2), orders(2), rejection}, []string{"were not rejected"}},
+		{"going back", []Record{turn(3), turn(2)}, []string{"turn 2 started as turn 4"}},
+	}
+	for _, tt := range tests {
+		result, err := Replay(Recording{
+			Meta:    Meta{Version: recordingVersion, Combat: "classic"},
+			Records: tt.records,
+		}, io.Discard)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(result.Mismatches) != len(tt.mismatches) {
+			t.Errorf("%s: got mismatches %q, want %q", tt.name, result.Mismatches, tt.mismatches)
+			continue
+		}
+		for i, want := range tt.mismatches {
+			if !strings.Contains(result.Mismatches[i], want) {
+				t.Errorf("%s: got mismatch %q, want %q", tt.name, result.Mismatches[i], want)
+			}
+		}
+	}
+
+	_, err := Replay(Recording{
+		Meta:    Meta{Version: recordingVersion, Combat: "classic"},
+		Records: []Record{turn(maxMissedTurns + 2)},
+	}, io.Discard)
+	if err == nil {
+		t.Error("caught up on more turns than maxMissedTurns")
+	}
+}
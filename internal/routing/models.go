@@ -6,6 +6,13 @@ type PlayingState struct {
 	IsPaused bool
 }
 
+// TurnStart is broadcast by a server running in turn mode. Orders for the
+// turn have to arrive before the deadline.
+type TurnStart struct {
+	Turn     int
+	Deadline time.Time
+}
+
 type GameLog struct {
 	CurrentTime time.Time
 	Message     string
@@ -0,0 +1,5 @@
+// Package perilpb holds the protobuf messages for the Peril types that can
+// travel as application/protobuf.
+package perilpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative gamelog.proto
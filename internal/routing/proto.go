@@ -0,0 +1,33 @@
+package routing
+
+import (
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing/perilpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// MarshalProto lets the protobuf codec send game logs as perilpb.GameLog.
+func (gl GameLog) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&perilpb.GameLog{
+		CurrentTime: timestamppb.New(gl.CurrentTime),
+		Message:     gl.Message,
+		Username:    gl.Username,
+	})
+}
+
+// UnmarshalProto decodes a perilpb.GameLog.
+func (gl *GameLog) UnmarshalProto(data []byte) error {
+	m := &perilpb.GameLog{}
+	err := proto.Unmarshal(data, m)
+	if err != nil {
+		return err
+	}
+	*gl = GameLog{
+		Message:  m.GetMessage(),
+		Username: m.GetUsername(),
+	}
+	if m.CurrentTime != nil {
+		gl.CurrentTime = m.CurrentTime.AsTime()
+	}
+	return nil
+}
@@ -7,10 +7,25 @@ const (
 
 	PauseKey = "pause"
 
+	TurnKey = "turn"
+
 	GameLogSlug = "game_logs"
+
+	IntentsPrefix = "intents"
+
+	WorldDeltasPrefix = "world_deltas"
+
+	OrdersPrefix = "orders"
+
+	DiplomacyPrefix = "diplomacy"
 )
 
 const (
 	ExchangePerilDirect = "peril_direct"
 	ExchangePerilTopic  = "peril_topic"
+	ExchangePerilDLX    = "peril_dlx"
+)
+
+const (
+	QueuePerilDLQ = "peril_dlq"
 )
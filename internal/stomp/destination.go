@@ -0,0 +1,43 @@
+package stomp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Destination is where a RabbitMQ STOMP destination points. Exchange
+// destinations have an Exchange and a RoutingKey, which is a binding key
+// when subscribing. Queue destinations only have a Queue.
+type Destination struct {
+	Exchange   string
+	RoutingKey string
+	Queue      string
+	// Existing is set for /amq/queue destinations, which refer to a queue
+	// that has to exist already instead of declaring it.
+	Existing bool
+}
+
+func ExchangeDestination(exchange, routingKey string) string {
+	return "/exchange/" + exchange + "/" + routingKey
+}
+
+func QueueDestination(queue string) string {
+	return "/queue/" + queue
+}
+
+func ExistingQueueDestination(queue string) string {
+	return "/amq/queue/" + queue
+}
+
+func ParseDestination(dest string) (Destination, error) {
+	switch {
+	case strings.HasPrefix(dest, "/exchange/"):
+		exchange, key, _ := strings.Cut(strings.TrimPrefix(dest, "/exchange/"), "/")
+		return Destination{Exchange: exchange, RoutingKey: key}, nil
+	case strings.HasPrefix(dest, "/queue/"):
+		return Destination{Queue: strings.TrimPrefix(dest, "/queue/")}, nil
+	case strings.HasPrefix(dest, "/amq/queue/"):
+		return Destination{Queue: strings.TrimPrefix(dest, "/amq/queue/"), Existing: true}, nil
+	}
+	return Destination{}, fmt.Errorf("unsupported destination: %s", dest)
+}
@@ -0,0 +1,179 @@
+// Package stomp reads and writes STOMP 1.2 frames.
+package stomp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	CommandConnect     = "CONNECT"
+	CommandConnected   = "CONNECTED"
+	CommandSend        = "SEND"
+	CommandSubscribe   = "SUBSCRIBE"
+	CommandUnsubscribe = "UNSUBSCRIBE"
+	CommandAck         = "ACK"
+	CommandNack        = "NACK"
+	CommandDisconnect  = "DISCONNECT"
+	CommandMessage     = "MESSAGE"
+	CommandReceipt     = "RECEIPT"
+	CommandError       = "ERROR"
+)
+
+// Frame is a command, its headers and a body. When a header is repeated
+// only its first value counts, so Headers keeps just that one.
+type Frame struct {
+	Command string
+	Headers map[string]string
+	Body    []byte
+}
+
+func NewFrame(command string, headers ...string) Frame {
+	f := Frame{Command: command, Headers: map[string]string{}}
+	for i := 0; i+1 < len(headers); i += 2 {
+		f.Headers[headers[i]] = headers[i+1]
+	}
+	return f
+}
+
+// Read reads the next frame, skipping heart-beat newlines.
+func Read(r *bufio.Reader) (Frame, error) {
+	var command string
+	for command == "" {
+		line, err := readLine(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		command = line
+	}
+
+	// CONNECT and CONNECTED frames are not escaped, for compatibility with
+	// STOMP 1.0.
+	escaped := command != CommandConnect && command != CommandConnected
+	f := Frame{Command: command, Headers: map[string]string{}}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return Frame{}, err
+		}
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return Frame{}, fmt.Errorf("malformed header: %q", line)
+		}
+		if escaped {
+			name, err = unescape(name)
+			if err != nil {
+				return Frame{}, err
+			}
+			value, err = unescape(value)
+			if err != nil {
+				return Frame{}, err
+			}
+		}
+		if _, ok := f.Headers[name]; !ok {
+			f.Headers[name] = value
+		}
+	}
+
+	if length, ok := f.Headers["content-length"]; ok {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 {
+			return Frame{}, fmt.Errorf("malformed content-length: %q", length)
+		}
+		f.Body = make([]byte, n+1)
+		_, err = io.ReadFull(r, f.Body)
+		if err != nil {
+			return Frame{}, err
+		}
+		if f.Body[n] != 0 {
+			return Frame{}, errors.New("frame body is longer than its content-length")
+		}
+		f.Body = f.Body[:n]
+		return f, nil
+	}
+	body, err := r.ReadBytes(0)
+	if err != nil {
+		return Frame{}, err
+	}
+	f.Body = body[:len(body)-1]
+	return f, nil
+}
+
+// Write writes f with a content-length header, so bodies may contain NULs.
+func Write(w *bufio.Writer, f Frame) error {
+	escaped := f.Command != CommandConnect && f.Command != CommandConnected
+	w.WriteString(f.Command)
+	w.WriteByte('\n')
+	for name, value := range f.Headers {
+		if name == "content-length" {
+			continue
+		}
+		if escaped {
+			name, value = escape(name), escape(value)
+		}
+		w.WriteString(name)
+		w.WriteByte(':')
+		w.WriteString(value)
+		w.WriteByte('\n')
+	}
+	if len(f.Body) > 0 || f.Command == CommandSend || f.Command == CommandMessage {
+		fmt.Fprintf(w, "content-length:%d\n", len(f.Body))
+	}
+	w.WriteByte('\n')
+	w.Write(f.Body)
+	w.WriteByte(0)
+	return w.Flush()
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+var escaper = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", fmt.Errorf("malformed escape in %q", s)
+		}
+		switch s[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		case 'c':
+			b.WriteByte(':')
+		default:
+			return "", fmt.Errorf("malformed escape in %q", s)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,367 @@
+// Package stomptest runs a fake RabbitMQ STOMP plugin in-process, for
+// exercising pubsub.STOMPBroker without a real RabbitMQ.
+package stomptest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/stomp"
+)
+
+// Server speaks enough STOMP 1.2 to stand in for RabbitMQ: it understands
+// /exchange, /queue and /amq/queue destinations, the x-queue-name and queue
+// argument headers, receipts, ACK and NACK with the requeue header, and
+// checks the user-id header against the login.
+// Messages are routed by a pubsub.MemoryBroker, which tests can also reach
+// through Broker, e.g. to declare exchanges first.
+type Server struct {
+	// Login and Passcode are checked when set.
+	Login    string
+	Passcode string
+
+	broker   *pubsub.MemoryBroker
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[*conn]struct{}
+}
+
+// NewServer starts a server on a random local port.
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		broker:   pubsub.NewMemoryBroker(),
+		listener: l,
+		conns:    map[*conn]struct{}{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *Server) Broker() *pubsub.MemoryBroker {
+	return s.broker
+}
+
+// Close stops accepting connections and drops the open ones.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.netConn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{
+			server:  s,
+			netConn: nc,
+			w:       bufio.NewWriter(nc),
+			mem:     s.broker.Connect(),
+			gone:    make(chan struct{}),
+			subs:    map[string]*pubsub.Subscription{},
+			acks:    map[string]chan pubsub.AckType{},
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// conn is one client connection, with a MemoryConn of its own so exclusive
+// queues go away with it.
+type conn struct {
+	server  *Server
+	netConn net.Conn
+	mem     *pubsub.MemoryConn
+	login   string
+	gone    chan struct{}
+
+	writeMu sync.Mutex
+	w       *bufio.Writer
+
+	mu     sync.Mutex
+	nextID uint64
+	subs   map[string]*pubsub.Subscription
+	acks   map[string]chan pubsub.AckType
+}
+
+func (c *conn) serve() {
+	defer func() {
+		close(c.gone)
+		c.netConn.Close()
+		c.mem.Close()
+	}()
+
+	r := bufio.NewReader(c.netConn)
+	f, err := stomp.Read(r)
+	if err != nil {
+		return
+	}
+	if f.Command != stomp.CommandConnect && f.Command != "STOMP" {
+		c.fail(f, errors.New("expected CONNECT"))
+		return
+	}
+	if c.server.Login != "" && (f.Headers["login"] != c.server.Login || f.Headers["passcode"] != c.server.Passcode) {
+		c.fail(f, errors.New("access refused"))
+		return
+	}
+	c.login = f.Headers["login"]
+	c.send(stomp.NewFrame(stomp.CommandConnected, "version", "1.2", "heart-beat", "0,0"))
+
+	for {
+		f, err := stomp.Read(r)
+		if err != nil {
+			return
+		}
+		if f.Command == stomp.CommandDisconnect {
+			c.receipt(f)
+			return
+		}
+		err = c.handle(f)
+		if err != nil {
+			c.fail(f, err)
+			return
+		}
+		c.receipt(f)
+	}
+}
+
+func (c *conn) handle(f stomp.Frame) error {
+	switch f.Command {
+	case stomp.CommandSend:
+		return c.publish(f)
+	case stomp.CommandSubscribe:
+		return c.subscribe(f)
+	case stomp.CommandUnsubscribe:
+		c.mu.Lock()
+		sub, ok := c.subs[f.Headers["id"]]
+		delete(c.subs, f.Headers["id"])
+		c.mu.Unlock()
+		if !ok {
+			return fmt.Errorf("no subscription %s", f.Headers["id"])
+		}
+		// Closing waits for a delivery that may still need an ACK from this
+		// very connection.
+		go sub.Close(context.Background())
+		return nil
+	case stomp.CommandAck, stomp.CommandNack:
+		c.mu.Lock()
+		ack, ok := c.acks[f.Headers["id"]]
+		delete(c.acks, f.Headers["id"])
+		c.mu.Unlock()
+		if !ok {
+			return fmt.Errorf("unknown ack id %s", f.Headers["id"])
+		}
+		switch {
+		case f.Command == stomp.CommandAck:
+			ack <- pubsub.Ack
+		case f.Headers["requeue"] == "false":
+			ack <- pubsub.NackDiscard
+		default:
+			ack <- pubsub.NackRequeue
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported command %s", f.Command)
+}
+
+func (c *conn) publish(f stomp.Frame) error {
+	dest, err := stomp.ParseDestination(f.Headers["destination"])
+	if err != nil {
+		return err
+	}
+	// RabbitMQ checks the user-id header like AMQP's user-id property.
+	if userID := f.Headers["user-id"]; userID != "" && c.login != "" && userID != c.login {
+		return fmt.Errorf("user-id %s does not match the login %s", userID, c.login)
+	}
+	msg := pubsub.Publishing{
+		ContentType: f.Headers["content-type"],
+		Headers:     map[string]any{},
+		Body:        f.Body,
+	}
+	for name, value := range f.Headers {
+		switch name {
+		case "destination", "content-type", "content-length", "receipt", "persistent",
+			"durable", "auto-delete", "exclusive", "x-message-ttl", "x-dead-letter-exchange", "x-dead-letter-routing-key":
+			continue
+		}
+		msg.Headers[name] = value
+	}
+
+	if dest.Queue == "" {
+		return c.mem.Publish(context.Background(), dest.Exchange, dest.RoutingKey, msg)
+	}
+	if !dest.Existing {
+		err := c.mem.DeclareQueue(dest.Queue, queueOptions(f))
+		if err != nil {
+			return err
+		}
+	}
+	return c.mem.Publish(context.Background(), "", dest.Queue, msg)
+}
+
+func (c *conn) subscribe(f stomp.Frame) error {
+	id := f.Headers["id"]
+	if id == "" {
+		return errors.New("SUBSCRIBE needs an id")
+	}
+	if f.Headers["ack"] != "client-individual" {
+		return errors.New("only ack:client-individual is supported")
+	}
+	dest, err := stomp.ParseDestination(f.Headers["destination"])
+	if err != nil {
+		return err
+	}
+
+	queue := dest.Queue
+	switch {
+	case dest.Queue == "":
+		queue = f.Headers["x-queue-name"]
+		if queue == "" {
+			c.mu.Lock()
+			c.nextID++
+			queue = "stomp-subscription-" + strconv.FormatUint(c.nextID, 10)
+			c.mu.Unlock()
+		}
+		err = c.bind(f, dest, queue)
+	case !dest.Existing:
+		err = c.mem.DeclareQueue(queue, queueOptions(f))
+	}
+	if err != nil {
+		return err
+	}
+
+	sub, err := c.mem.Consume(queue, 0, func(d pubsub.Delivery) pubsub.AckType {
+		return c.deliver(id, d)
+	})
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.subs[id] = sub
+	c.mu.Unlock()
+	return nil
+}
+
+// bind declares the queue behind an exchange subscription the way RabbitMQ
+// would: exclusive and durable queues like DeclareAndBind's, anything with
+// queue arguments like DeclareQueue's.
+func (c *conn) bind(f stomp.Frame, dest stomp.Destination, queue string) error {
+	_, ttl := f.Headers["x-message-ttl"]
+	_, dlrk := f.Headers["x-dead-letter-routing-key"]
+	switch {
+	case f.Headers["exclusive"] == "true":
+		return c.mem.DeclareAndBind(dest.Exchange, queue, dest.RoutingKey, pubsub.TransientQueue)
+	case !ttl && !dlrk && f.Headers["x-dead-letter-exchange"] == pubsub.DeadLetterExchange:
+		return c.mem.DeclareAndBind(dest.Exchange, queue, dest.RoutingKey, pubsub.DurableQueue)
+	}
+	err := c.mem.DeclareQueue(queue, queueOptions(f))
+	if err != nil {
+		return err
+	}
+	return c.mem.BindQueue(queue, dest.Exchange, dest.RoutingKey)
+}
+
+func queueOptions(f stomp.Frame) pubsub.QueueOptions {
+	opts := pubsub.QueueOptions{
+		Durable:              f.Headers["durable"] != "false",
+		Exclusive:            f.Headers["exclusive"] == "true",
+		DeadLetterExchange:   f.Headers["x-dead-letter-exchange"],
+		DeadLetterRoutingKey: f.Headers["x-dead-letter-routing-key"],
+	}
+	if ms, err := strconv.Atoi(f.Headers["x-message-ttl"]); err == nil {
+		opts.MessageTTL = time.Duration(ms) * time.Millisecond
+	}
+	return opts
+}
+
+// deliver sends d as a MESSAGE and waits for the client to settle it.
+func (c *conn) deliver(subscription string, d pubsub.Delivery) pubsub.AckType {
+	c.mu.Lock()
+	c.nextID++
+	ackID := strconv.FormatUint(c.nextID, 10)
+	ack := make(chan pubsub.AckType, 1)
+	c.acks[ackID] = ack
+	c.mu.Unlock()
+
+	f := stomp.NewFrame(stomp.CommandMessage,
+		"subscription", subscription,
+		"message-id", ackID,
+		"ack", ackID,
+		"content-type", d.ContentType,
+		"redelivered", strconv.FormatBool(d.Redelivered),
+	)
+	f.Headers["destination"] = stomp.ExchangeDestination(d.Exchange, d.RoutingKey)
+	if d.Exchange == "" {
+		f.Headers["destination"] = stomp.QueueDestination(d.RoutingKey)
+	}
+	for name, value := range d.Headers {
+		f.Headers[name] = fmt.Sprint(value)
+	}
+	f.Body = d.Body
+	if c.send(f) != nil {
+		return pubsub.NackRequeue
+	}
+
+	select {
+	case ackType := <-ack:
+		return ackType
+	case <-c.gone:
+		return pubsub.NackRequeue
+	}
+}
+
+func (c *conn) send(f stomp.Frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return stomp.Write(c.w, f)
+}
+
+func (c *conn) receipt(f stomp.Frame) {
+	if id, ok := f.Headers["receipt"]; ok {
+		c.send(stomp.NewFrame(stomp.CommandReceipt, "receipt-id", id))
+	}
+}
+
+// fail sends an ERROR frame; like RabbitMQ the connection is closed after.
+func (c *conn) fail(f stomp.Frame, err error) {
+	e := stomp.NewFrame(stomp.CommandError, "message", err.Error())
+	if id, ok := f.Headers["receipt"]; ok {
+		e.Headers["receipt-id"] = id
+	}
+	c.send(e)
+}
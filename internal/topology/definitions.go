@@ -0,0 +1,190 @@
+package topology
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+const defaultVhost = "/"
+
+// Definitions is the subset of a RabbitMQ definitions file that describes
+// exchanges, queues and bindings. It can be imported through the management
+// UI or `rabbitmqctl import_definitions`.
+type Definitions struct {
+	Exchanges []ExchangeDefinition `json:"exchanges"`
+	Queues    []QueueDefinition    `json:"queues"`
+	Bindings  []BindingDefinition  `json:"bindings"`
+}
+
+type ExchangeDefinition struct {
+	Name       string         `json:"name"`
+	Vhost      string         `json:"vhost"`
+	Type       string         `json:"type"`
+	Durable    bool           `json:"durable"`
+	AutoDelete bool           `json:"auto_delete"`
+	Internal   bool           `json:"internal"`
+	Arguments  map[string]any `json:"arguments"`
+}
+
+type QueueDefinition struct {
+	Name       string         `json:"name"`
+	Vhost      string         `json:"vhost"`
+	Durable    bool           `json:"durable"`
+	AutoDelete bool           `json:"auto_delete"`
+	Arguments  map[string]any `json:"arguments"`
+}
+
+type BindingDefinition struct {
+	Source          string         `json:"source"`
+	Vhost           string         `json:"vhost"`
+	Destination     string         `json:"destination"`
+	DestinationType string         `json:"destination_type"`
+	RoutingKey      string         `json:"routing_key"`
+	Arguments       map[string]any `json:"arguments"`
+}
+
+func (t Topology) Definitions() Definitions {
+	defs := Definitions{}
+	for _, ex := range t.Exchanges {
+		defs.Exchanges = append(defs.Exchanges, ExchangeDefinition{
+			Name:      ex.Name,
+			Vhost:     defaultVhost,
+			Type:      string(ex.Kind),
+			Durable:   true,
+			Arguments: map[string]any{},
+		})
+	}
+	for _, q := range t.Queues {
+		args := map[string]any{}
+		if q.DeadLetter {
+			args["x-dead-letter-exchange"] = routing.ExchangePerilDLX
+		}
+		defs.Queues = append(defs.Queues, QueueDefinition{
+			Name:      q.Name,
+			Vhost:     defaultVhost,
+			Durable:   true,
+			Arguments: args,
+		})
+	}
+	for _, binding := range t.Bindings {
+		defs.Bindings = append(defs.Bindings, BindingDefinition{
+			Source:          binding.Exchange,
+			Vhost:           defaultVhost,
+			Destination:     binding.Queue,
+			DestinationType: "queue",
+			RoutingKey:      binding.RoutingKey,
+			Arguments:       map[string]any{},
+		})
+	}
+	return defs
+}
+
+func (d Definitions) Export(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(d)
+	if err != nil {
+		return fmt.Errorf("error encoding definitions: %v", err)
+	}
+	return nil
+}
+
+// managementClient gives up on a management API that stopped answering
+// instead of hanging whoever asked.
+var managementClient = &http.Client{Timeout: 10 * time.Second}
+
+// FetchDefinitions downloads the live definitions from the RabbitMQ
+// management API, e.g. http://localhost:15672.
+func FetchDefinitions(managementURL, username, password string) (Definitions, error) {
+	req, err := http.NewRequest(http.MethodGet, managementURL+"/api/definitions", nil)
+	if err != nil {
+		return Definitions{}, fmt.Errorf("error creating request: %v", err)
+	}
+	req.SetBasicAuth(username, password)
+
+	resp, err := managementClient.Do(req)
+	if err != nil {
+		return Definitions{}, fmt.Errorf("error fetching definitions: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Definitions{}, fmt.Errorf("error fetching definitions: %s", resp.Status)
+	}
+
+	defs := Definitions{}
+	err = json.NewDecoder(resp.Body).Decode(&defs)
+	if err != nil {
+		return Definitions{}, fmt.Errorf("error decoding definitions: %v", err)
+	}
+	return defs, nil
+}
+
+// Diff lists everything in want that is missing from have or declared
+// differently there. Extra definitions in have are ignored.
+func Diff(want, have Definitions) []string {
+	diffs := []string{}
+
+	exchanges := map[string]ExchangeDefinition{}
+	for _, ex := range have.Exchanges {
+		exchanges[ex.Vhost+ex.Name] = ex
+	}
+	for _, ex := range want.Exchanges {
+		got, ok := exchanges[ex.Vhost+ex.Name]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("missing exchange %s (%s)", ex.Name, ex.Type))
+		case got.Type != ex.Type:
+			diffs = append(diffs, fmt.Sprintf("exchange %s is %s, want %s", ex.Name, got.Type, ex.Type))
+		case got.Durable != ex.Durable:
+			diffs = append(diffs, fmt.Sprintf("exchange %s has durable=%v, want %v", ex.Name, got.Durable, ex.Durable))
+		}
+	}
+
+	queues := map[string]QueueDefinition{}
+	for _, q := range have.Queues {
+		queues[q.Vhost+q.Name] = q
+	}
+	for _, q := range want.Queues {
+		got, ok := queues[q.Vhost+q.Name]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("missing queue %s", q.Name))
+		case got.Durable != q.Durable:
+			diffs = append(diffs, fmt.Sprintf("queue %s has durable=%v, want %v", q.Name, got.Durable, q.Durable))
+		case !sameArguments(got.Arguments, q.Arguments):
+			diffs = append(diffs, fmt.Sprintf("queue %s has arguments %v, want %v", q.Name, got.Arguments, q.Arguments))
+		}
+	}
+
+	bindings := map[bindingKey]struct{}{}
+	for _, b := range have.Bindings {
+		bindings[b.key()] = struct{}{}
+	}
+	for _, b := range want.Bindings {
+		if _, ok := bindings[b.key()]; !ok {
+			diffs = append(diffs, fmt.Sprintf("missing binding %s -> %s (%q)", b.Source, b.Destination, b.RoutingKey))
+		}
+	}
+	return diffs
+}
+
+type bindingKey struct {
+	source, vhost, destination, destinationType, routingKey string
+}
+
+func (b BindingDefinition) key() bindingKey {
+	return bindingKey{b.Source, b.Vhost, b.Destination, b.DestinationType, b.RoutingKey}
+}
+
+func sameArguments(a, b map[string]any) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
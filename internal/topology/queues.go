@@ -0,0 +1,43 @@
+package topology
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// QueueStats is how a queue is doing according to the management API.
+type QueueStats struct {
+	Name                   string `json:"name"`
+	Durable                bool   `json:"durable"`
+	State                  string `json:"state"`
+	Consumers              int    `json:"consumers"`
+	Messages               int    `json:"messages"`
+	MessagesReady          int    `json:"messages_ready"`
+	MessagesUnacknowledged int    `json:"messages_unacknowledged"`
+}
+
+// FetchQueues lists the queues of the default vhost.
+func FetchQueues(managementURL, username, password string) ([]QueueStats, error) {
+	req, err := http.NewRequest(http.MethodGet, managementURL+"/api/queues/%2F", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.SetBasicAuth(username, password)
+
+	resp, err := managementClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching queues: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching queues: %s", resp.Status)
+	}
+
+	queues := []QueueStats{}
+	err = json.NewDecoder(resp.Body).Decode(&queues)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding queues: %v", err)
+	}
+	return queues, nil
+}
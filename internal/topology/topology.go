@@ -0,0 +1,96 @@
+package topology
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type Exchange struct {
+	Name string
+	Kind pubsub.ExchangeKind
+}
+
+// Queue is a durable queue shared by all players. Queues with DeadLetter set
+// send discarded messages to peril_dlx, like every queue declared through
+// pubsub.DeclareAndBind, and are declared along with their first binding.
+type Queue struct {
+	Name       string
+	DeadLetter bool
+}
+
+type Binding struct {
+	Exchange   string
+	Queue      string
+	RoutingKey string
+}
+
+type Topology struct {
+	Exchanges []Exchange
+	Queues    []Queue
+	Bindings  []Binding
+}
+
+// Peril is everything the game expects to exist on the broker. Per-player
+// transient queues are left out, clients declare those when they join.
+func Peril() Topology {
+	return Topology{
+		Exchanges: []Exchange{
+			{Name: routing.ExchangePerilDirect, Kind: pubsub.ExchangeKindDirect},
+			{Name: routing.ExchangePerilTopic, Kind: pubsub.ExchangeKindTopic},
+			{Name: routing.ExchangePerilDLX, Kind: pubsub.ExchangeKindFanout},
+		},
+		Queues: []Queue{
+			{Name: routing.QueuePerilDLQ},
+			{Name: routing.GameLogSlug, DeadLetter: true},
+			{Name: routing.IntentsPrefix, DeadLetter: true},
+			{Name: routing.OrdersPrefix, DeadLetter: true},
+			{Name: routing.DiplomacyPrefix, DeadLetter: true},
+		},
+		Bindings: []Binding{
+			{Exchange: routing.ExchangePerilDLX, Queue: routing.QueuePerilDLQ, RoutingKey: ""},
+			{Exchange: routing.ExchangePerilTopic, Queue: routing.GameLogSlug, RoutingKey: routing.GameLogSlug + ".*"},
+			{Exchange: routing.ExchangePerilTopic, Queue: routing.IntentsPrefix, RoutingKey: routing.IntentsPrefix + ".*"},
+			{Exchange: routing.ExchangePerilTopic, Queue: routing.OrdersPrefix, RoutingKey: routing.OrdersPrefix + ".*"},
+			{Exchange: routing.ExchangePerilTopic, Queue: routing.DiplomacyPrefix, RoutingKey: routing.DiplomacyPrefix + ".*"},
+		},
+	}
+}
+
+// Apply declares the topology. Declarations are idempotent, so it is safe to
+// run on every startup.
+func (t Topology) Apply(b pubsub.Broker) error {
+	for _, ex := range t.Exchanges {
+		err := b.DeclareExchange(ex.Name, ex.Kind)
+		if err != nil {
+			return fmt.Errorf("error declaring exchange %s: %v", ex.Name, err)
+		}
+	}
+
+	deadLetter := map[string]bool{}
+	for _, q := range t.Queues {
+		deadLetter[q.Name] = q.DeadLetter
+		if q.DeadLetter {
+			// declared together with its bindings below
+			continue
+		}
+		err := b.DeclareQueue(q.Name, pubsub.QueueOptions{Durable: true})
+		if err != nil {
+			return fmt.Errorf("error declaring queue %s: %v", q.Name, err)
+		}
+	}
+
+	for _, binding := range t.Bindings {
+		var err error
+		if deadLetter[binding.Queue] {
+			err = b.DeclareAndBind(binding.Exchange, binding.Queue, binding.RoutingKey, pubsub.DurableQueue)
+		} else {
+			err = b.BindQueue(binding.Queue, binding.Exchange, binding.RoutingKey)
+		}
+		if err != nil {
+			return fmt.Errorf("error binding %s to %s: %v", binding.Queue, binding.Exchange, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package topology
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestApply(t *testing.T) {
+	conn := pubsub.NewMemoryBroker().Connect()
+	defer conn.Close()
+	// Applying again on startup changes nothing.
+	for range 2 {
+		err := Peril().Apply(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Intents a handler discards end up in the dead-letter queue.
+	intents := make(chan pubsub.Delivery, 1)
+	sub, err := conn.Consume(routing.IntentsPrefix, 0, func(d pubsub.Delivery) pubsub.AckType {
+		intents <- d
+		return pubsub.NackDiscard
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close(context.Background())
+	dead := make(chan pubsub.Delivery, 1)
+	dlqSub, err := conn.Consume(routing.QueuePerilDLQ, 0, func(d pubsub.Delivery) pubsub.AckType {
+		dead <- d
+		return pubsub.Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dlqSub.Close(context.Background())
+
+	err = conn.Publish(context.Background(), routing.ExchangePerilTopic, routing.IntentsPrefix+".alice", pubsub.Publishing{Body: []byte("spawn")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []chan pubsub.Delivery{intents, dead} {
+		select {
+		case d := <-c:
+			if string(d.Body) != "spawn" {
+				t.Errorf("got body %q", d.Body)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the intent")
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	want := Peril().Definitions()
+
+	// What export writes is what RabbitMQ hands back.
+	buf := &bytes.Buffer{}
+	err := want.Export(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := Definitions{}
+	err = json.Unmarshal(buf.Bytes(), &have)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs := Diff(want, have); len(diffs) != 0 {
+		t.Errorf("exported definitions differ: %v", diffs)
+	}
+
+	have.Exchanges = slices.DeleteFunc(have.Exchanges, func(ex ExchangeDefinition) bool {
+		return ex.Name == routing.ExchangePerilDLX
+	})
+	for i, q := range have.Queues {
+		if q.Name == routing.IntentsPrefix {
+			have.Queues[i].Arguments = map[string]any{}
+		}
+	}
+	have.Bindings = have.Bindings[1:]
+	got := Diff(want, have)
+	wantDiffs := []string{
+		"missing exchange peril_dlx (fanout)",
+		"queue intents has arguments map[], want map[x-dead-letter-exchange:peril_dlx]",
+		`missing binding peril_dlx -> peril_dlq ("")`,
+	}
+	if !slices.Equal(got, wantDiffs) {
+		t.Errorf("got diffs %q, want %q", got, wantDiffs)
+	}
+}
+
+func TestFetchDefinitions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		if r.URL.Path != "/api/definitions" || username != "guest" || password != "guest" {
+			http.Error(w, "not authorized", http.StatusUnauthorized)
+			return
+		}
+		Peril().Definitions().Export(w)
+	}))
+	defer srv.Close()
+
+	have, err := FetchDefinitions(srv.URL, "guest", "guest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs := Diff(Peril().Definitions(), have); len(diffs) != 0 {
+		t.Errorf("fetched definitions differ: %v", diffs)
+	}
+
+	_, err = FetchDefinitions(srv.URL, "guest", "wrong")
+	if err == nil {
+		t.Error("fetched definitions with the wrong password")
+	}
+}
@@ -13,7 +13,21 @@ case "$1" in
         echo "Fetching logs for RabbitMQ container..."
         docker logs -f rabbitmq
         ;;
+    player)
+        # Players log in as themselves: RabbitMQ checks every message's
+        # user-id against the login, which is how the server knows who sent it.
+        echo "Adding player $2..."
+        docker exec rabbitmq rabbitmqctl add_user "$2" "$3"
+        docker exec rabbitmq rabbitmqctl set_permissions -p / "$2" ".*" ".*" ".*"
+        ;;
+    impersonator)
+        # The gateway and the bots publish for many players over one login.
+        echo "Adding impersonator $2..."
+        docker exec rabbitmq rabbitmqctl add_user "$2" "$3"
+        docker exec rabbitmq rabbitmqctl set_user_tags "$2" impersonator
+        docker exec rabbitmq rabbitmqctl set_permissions -p / "$2" ".*" ".*" ".*"
+        ;;
     *)
-        echo "Usage: $0 {start|stop|logs}"
+        echo "Usage: $0 {start|stop|logs|player <name> <password>|impersonator <name> <password>}"
         exit 1
 esac